@@ -5,6 +5,7 @@ import (
 	"io"
 	"os"
 	"os/user"
+	"path"
 	"strconv"
 	"strings"
 
@@ -50,6 +51,64 @@ func acmeCurrentWin() (*acme.Win, error) {
 	return win, nil
 }
 
+// Special values for the -win flag.
+const (
+	winAll       = "all"
+	winSelection = "sel"
+)
+
+// otherWindowParts returns parts holding the bodies of the acme
+// windows other than the one with the given id that are selected
+// by spec: all windows if spec is "all", windows with a non-empty
+// selection if spec is "sel", and otherwise windows whose names,
+// or the last elements of their names, match the glob pattern spec.
+// Directory windows and windows whose names start with "+" are
+// never included. Selections are surrounded by delim.
+func otherWindowParts(id int, spec, delim string) ([]Part, error) {
+	wins, err := acme.Windows()
+	if err != nil {
+		return nil, fmt.Errorf("cannot list acme windows: %v", err)
+	}
+	var parts []Part
+	for _, w := range wins {
+		base := path.Base(w.Name)
+		if w.ID == id || strings.HasSuffix(w.Name, "/") || strings.HasPrefix(base, "+") {
+			continue
+		}
+		if spec != winAll && spec != winSelection {
+			ok1, err := path.Match(spec, w.Name)
+			if err != nil {
+				return nil, fmt.Errorf("bad window pattern %q: %v", spec, err)
+			}
+			ok2, _ := path.Match(spec, base)
+			if !ok1 && !ok2 {
+				continue
+			}
+		}
+		body, err := readWindow(w.ID)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read acme window %s: %v", w.Name, err)
+		}
+		body.delim = delim
+		if spec == winSelection && len(body.selection) == 0 {
+			continue
+		}
+		parts = append(parts, body.windowPart())
+	}
+	return parts, nil
+}
+
+// readWindow returns the contents of the body of the
+// acme window with the given id.
+func readWindow(id int) (*bodyInfo, error) {
+	win, err := acme.Open(id, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer win.CloseFiles()
+	return readBody(win)
+}
+
 func runeOffset2ByteOffset(b []byte, off int) int {
 	r := 0
 	for i, _ := range string(b) {
@@ -0,0 +1,408 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"iter"
+	"net/http"
+	"os"
+	"strings"
+)
+
+const (
+	anthropicDefaultBaseURL = "https://api.anthropic.com"
+	anthropicVersion        = "2023-06-01"
+	anthropicMaxTokens      = 16384
+)
+
+// anthropicBackend implements Backend using the Anthropic
+// Messages API.
+type anthropicBackend struct {
+	client  *http.Client
+	baseURL string
+	apiKey  string
+}
+
+// newAnthropicBackend returns a backend that talks to Anthropic,
+// relying on $ANTHROPIC_API_KEY in the environment.
+// The API endpoint can be changed by setting $ANTHROPIC_BASE_URL.
+func newAnthropicBackend() (*anthropicBackend, error) {
+	key := os.Getenv("ANTHROPIC_API_KEY")
+	if key == "" {
+		return nil, fmt.Errorf("no value set for $ANTHROPIC_API_KEY")
+	}
+	baseURL := os.Getenv("ANTHROPIC_BASE_URL")
+	if baseURL == "" {
+		baseURL = anthropicDefaultBaseURL
+	}
+	return &anthropicBackend{
+		client:  http.DefaultClient,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		apiKey:  key,
+	}, nil
+}
+
+type anthropicRequest struct {
+	Model     string             `json:"model"`
+	MaxTokens int                `json:"max_tokens"`
+	System    string             `json:"system,omitempty"`
+	Messages  []anthropicMessage `json:"messages"`
+	Tools     []anthropicTool    `json:"tools,omitempty"`
+	Thinking  *anthropicThinking `json:"thinking,omitempty"`
+	Stream    bool               `json:"stream"`
+}
+
+type anthropicThinking struct {
+	Type         string `json:"type"`
+	BudgetTokens int    `json:"budget_tokens"`
+}
+
+// anthropicThinkingBudgets holds the number of tokens
+// that the model may use for thinking at each
+// reasoning effort.
+var anthropicThinkingBudgets = map[string]int{
+	"low":    2048,
+	"medium": 8192,
+	"high":   16384,
+}
+
+type anthropicMessage struct {
+	Role string `json:"role"`
+
+	// Content holds either a string or a []anthropicBlock.
+	Content any `json:"content"`
+}
+
+type anthropicBlock struct {
+	Type      string           `json:"type"`
+	Text      string           `json:"text,omitempty"`
+	ID        string           `json:"id,omitempty"`
+	Name      string           `json:"name,omitempty"`
+	Input     json.RawMessage  `json:"input,omitempty"`
+	ToolUseID string           `json:"tool_use_id,omitempty"`
+	Content   string           `json:"content,omitempty"`
+	Thinking  string           `json:"thinking,omitempty"`
+	Signature string           `json:"signature,omitempty"`
+	Data      string           `json:"data,omitempty"`
+	Source    *anthropicSource `json:"source,omitempty"`
+}
+
+// anthropicSource holds the content of an image
+// or document block.
+type anthropicSource struct {
+	Type      string `json:"type"`
+	MediaType string `json:"media_type"`
+	Data      string `json:"data"`
+}
+
+type anthropicTool struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	InputSchema map[string]any `json:"input_schema"`
+}
+
+// anthropicStreamEvent holds the union of all the fields
+// we care about in the Messages streaming events.
+type anthropicStreamEvent struct {
+	Type    string `json:"type"`
+	Message struct {
+		Usage anthropicUsage `json:"usage"`
+	} `json:"message"`
+	Index        int            `json:"index"`
+	ContentBlock anthropicBlock `json:"content_block"`
+	Delta        struct {
+		Type        string `json:"type"`
+		Text        string `json:"text"`
+		Thinking    string `json:"thinking"`
+		Signature   string `json:"signature"`
+		PartialJSON string `json:"partial_json"`
+		StopReason  string `json:"stop_reason"`
+	} `json:"delta"`
+	Usage anthropicUsage `json:"usage"`
+	Error struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+type anthropicUsage struct {
+	InputTokens          int64 `json:"input_tokens"`
+	CacheReadInputTokens int64 `json:"cache_read_input_tokens"`
+	OutputTokens         int64 `json:"output_tokens"`
+}
+
+// anthropicPrefill is used to start the assistant's reply
+// so that it's more likely to produce JSON.
+// Anthropic doesn't provide a JSON mode.
+// We can't use it when there are tools, because the
+// model must be free to call a tool before replying,
+// or when thinking, because the API doesn't allow it.
+const anthropicPrefill = "{"
+
+// usePrefill reports whether the reply to req
+// should be prefilled with anthropicPrefill.
+func usePrefill(req *Request) bool {
+	return len(req.Tools) == 0 && req.Effort == ""
+}
+
+func (b *anthropicBackend) Stream(ctx context.Context, req *Request) iter.Seq2[Event, error] {
+	events := func(yield func(Event, error) bool) {
+		if err := b.stream(ctx, req, yield); err != nil {
+			yield(Event{}, err)
+		}
+	}
+	if !usePrefill(req) {
+		return skipToJSON(events)
+	}
+	return events
+}
+
+// anthropicTurn holds the result of a single request
+// to the Messages API.
+type anthropicTurn struct {
+	blocks     []anthropicBlock
+	stopReason string
+	usage      Usage
+
+	// stopped records that the consumer of the
+	// events has stopped early.
+	stopped bool
+}
+
+func (b *anthropicBackend) stream(ctx context.Context, req *Request, yield func(Event, error) bool) error {
+	areq, err := b.request(req)
+	if err != nil {
+		return err
+	}
+	if usePrefill(req) {
+		if !yield(Event{Text: anthropicPrefill}, nil) {
+			return nil
+		}
+	}
+	var usage Usage
+	for round := 0; ; round++ {
+		turn, err := b.send(ctx, areq, yield)
+		if err != nil {
+			return err
+		}
+		if turn.stopped {
+			return nil
+		}
+		usage.add(turn.usage)
+		switch turn.stopReason {
+		case "tool_use":
+		case "max_tokens":
+			return fmt.Errorf("reply truncated: maximum token count reached")
+		default:
+			yield(Event{
+				Done:  true,
+				Usage: &usage,
+			}, nil)
+			return nil
+		}
+		if round >= maxToolRounds {
+			return fmt.Errorf("too many rounds of tool calls")
+		}
+		var blocks, results []anthropicBlock
+		for _, block := range turn.blocks {
+			switch block.Type {
+			case "text":
+				if block.Text == "" {
+					// The API rejects empty text blocks.
+					continue
+				}
+			case "tool_use":
+				results = append(results, anthropicBlock{
+					Type:      "tool_result",
+					ToolUseID: block.ID,
+					Content:   callTool(req.Tools, block.Name, string(block.Input)),
+				})
+			}
+			blocks = append(blocks, block)
+		}
+		areq.Messages = append(areq.Messages, anthropicMessage{
+			Role:    "assistant",
+			Content: blocks,
+		}, anthropicMessage{
+			Role:    "user",
+			Content: results,
+		})
+	}
+}
+
+// send sends a single request, yielding all reply text as
+// it arrives, and returns the resulting content blocks.
+func (b *anthropicBackend) send(ctx context.Context, areq *anthropicRequest, yield func(Event, error) bool) (*anthropicTurn, error) {
+	body, err := json.Marshal(areq)
+	if err != nil {
+		return nil, err
+	}
+	hreq, err := http.NewRequestWithContext(ctx, "POST", b.baseURL+"/v1/messages", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	hreq.Header.Set("Content-Type", "application/json")
+	hreq.Header.Set("x-api-key", b.apiKey)
+	hreq.Header.Set("anthropic-version", anthropicVersion)
+	resp, err := b.client.Do(hreq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		return nil, fmt.Errorf("anthropic API error: %s: %s", resp.Status, bytes.TrimSpace(data))
+	}
+	var turn anthropicTurn
+	// partialJSON holds the tool input for each block
+	// as it arrives.
+	var partialJSON []string
+	for sse, err := range sseEvents(resp.Body) {
+		if err != nil {
+			return nil, err
+		}
+		var ev anthropicStreamEvent
+		if err := json.Unmarshal([]byte(sse.Data), &ev); err != nil {
+			return nil, fmt.Errorf("cannot unmarshal %q event: %v", sse.Event, err)
+		}
+		switch ev.Type {
+		case "message_start":
+			u := ev.Message.Usage
+			turn.usage.InputTokens = u.InputTokens + u.CacheReadInputTokens
+			turn.usage.CachedTokens = u.CacheReadInputTokens
+			turn.usage.OutputTokens = u.OutputTokens
+		case "content_block_start":
+			for ev.Index >= len(turn.blocks) {
+				turn.blocks = append(turn.blocks, anthropicBlock{})
+				partialJSON = append(partialJSON, "")
+			}
+			block := ev.ContentBlock
+			block.Input = nil
+			turn.blocks[ev.Index] = block
+		case "content_block_delta":
+			if ev.Index >= len(turn.blocks) {
+				return nil, fmt.Errorf("delta for unknown content block %d", ev.Index)
+			}
+			switch ev.Delta.Type {
+			case "text_delta":
+				turn.blocks[ev.Index].Text += ev.Delta.Text
+				if !yield(Event{Text: ev.Delta.Text}, nil) {
+					turn.stopped = true
+					return &turn, nil
+				}
+			case "thinking_delta":
+				turn.blocks[ev.Index].Thinking += ev.Delta.Thinking
+				if !yield(Event{Reasoning: ev.Delta.Thinking}, nil) {
+					turn.stopped = true
+					return &turn, nil
+				}
+			case "signature_delta":
+				// The signature must be sent back with the
+				// thinking block when calling tools.
+				turn.blocks[ev.Index].Signature += ev.Delta.Signature
+			case "input_json_delta":
+				partialJSON[ev.Index] += ev.Delta.PartialJSON
+			}
+		case "message_delta":
+			turn.usage.OutputTokens = ev.Usage.OutputTokens
+			turn.stopReason = ev.Delta.StopReason
+		case "message_stop":
+			for i, block := range turn.blocks {
+				if block.Type != "tool_use" {
+					continue
+				}
+				input := partialJSON[i]
+				if input == "" {
+					input = "{}"
+				}
+				turn.blocks[i].Input = json.RawMessage(input)
+			}
+			return &turn, nil
+		case "error":
+			return nil, fmt.Errorf("anthropic API error: %s: %s", ev.Error.Type, ev.Error.Message)
+		}
+	}
+	return nil, io.ErrUnexpectedEOF
+}
+
+func (b *anthropicBackend) request(req *Request) (*anthropicRequest, error) {
+	areq := &anthropicRequest{
+		Model:     req.Model,
+		MaxTokens: anthropicMaxTokens,
+		System:    req.System,
+		Stream:    true,
+	}
+	for _, m := range req.Messages {
+		if m.Role == "assistant" {
+			areq.Messages = append(areq.Messages, anthropicMessage{
+				Role:    m.Role,
+				Content: m.Text,
+			})
+			continue
+		}
+		content, err := anthropicContent(m)
+		if err != nil {
+			return nil, err
+		}
+		areq.Messages = append(areq.Messages, anthropicMessage{
+			Role:    m.Role,
+			Content: content,
+		})
+	}
+	for _, t := range req.Tools {
+		areq.Tools = append(areq.Tools, anthropicTool{
+			Name:        t.Name,
+			Description: t.Description,
+			InputSchema: t.Parameters,
+		})
+	}
+	if req.Effort != "" {
+		budget, ok := anthropicThinkingBudgets[req.Effort]
+		if !ok {
+			return nil, fmt.Errorf("unknown reasoning effort %q", req.Effort)
+		}
+		areq.Thinking = &anthropicThinking{
+			Type:         "enabled",
+			BudgetTokens: budget,
+		}
+		// The thinking counts towards the maximum.
+		areq.MaxTokens += budget
+	}
+	if usePrefill(req) {
+		areq.Messages = append(areq.Messages, anthropicMessage{
+			Role:    "assistant",
+			Content: anthropicPrefill,
+		})
+	}
+	return areq, nil
+}
+
+// anthropicContent returns the content of the user message m,
+// with a text block for each part, and with images and
+// PDF documents as image and document blocks.
+func anthropicContent(m Message) ([]anthropicBlock, error) {
+	var blocks []anthropicBlock
+	err := userContent(m, func(text string) {
+		blocks = append(blocks, anthropicBlock{
+			Type: "text",
+			Text: text,
+		})
+	}, func(p Part) {
+		typ := "document"
+		if strings.HasPrefix(p.MediaType, "image/") {
+			typ = "image"
+		}
+		blocks = append(blocks, anthropicBlock{
+			Type: typ,
+			Source: &anthropicSource{
+				Type:      "base64",
+				MediaType: p.MediaType,
+				Data:      p.Content,
+			},
+		})
+	})
+	return blocks, err
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// anthropicReplayServer starts a server that replies to each
+// request to the Messages API with the next of the named SSE
+// streams in testdata/anthropic, and sets up the environment
+// so that newAnthropicBackend uses it. It returns a pointer
+// to the bodies of the requests received.
+func anthropicReplayServer(t *testing.T, streams ...string) *[]anthropicRequest {
+	t.Helper()
+	var reqs []anthropicRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/messages" || r.Header.Get("x-api-key") != "test-key" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		var areq anthropicRequest
+		if err := json.NewDecoder(r.Body).Decode(&areq); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		reqs = append(reqs, areq)
+		if len(reqs) > len(streams) {
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+			return
+		}
+		data, err := os.ReadFile(filepath.Join("testdata", "anthropic", streams[len(reqs)-1]))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write(data)
+	}))
+	t.Cleanup(srv.Close)
+	t.Setenv("ANTHROPIC_API_KEY", "test-key")
+	t.Setenv("ANTHROPIC_BASE_URL", srv.URL)
+	return &reqs
+}
+
+// collectEvents returns the text, usage and final error
+// of the given events.
+func collectEvents(t *testing.T, b Backend, req *Request) (text string, usage *Usage, _ error) {
+	t.Helper()
+	var buf strings.Builder
+	for ev, err := range b.Stream(context.Background(), req) {
+		if err != nil {
+			return buf.String(), usage, err
+		}
+		buf.WriteString(ev.Text)
+		if ev.Done {
+			usage = ev.Usage
+		}
+	}
+	return buf.String(), usage, nil
+}
+
+func testRequest() *Request {
+	return &Request{
+		Model: "claude-sonnet-4-5",
+		Messages: []Message{{
+			Role:  "user",
+			Parts: []Part{{Instructions: "say hello", Content: "x"}},
+		}},
+	}
+}
+
+func TestAnthropicText(t *testing.T) {
+	reqs := anthropicReplayServer(t, "text.sse")
+	b, err := newAnthropicBackend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	text, usage, err := collectEvents(t, b, testRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"parts":[{"type":"commentary","text":"hello"}]}`; text != want {
+		t.Errorf("got text %q; want %q", text, want)
+	}
+	if want := (Usage{InputTokens: 30, CachedTokens: 5, OutputTokens: 12}); usage == nil || *usage != want {
+		t.Errorf("got usage %+v; want %+v", usage, want)
+	}
+	// The reply is prefilled to encourage JSON.
+	msgs := (*reqs)[0].Messages
+	if last := msgs[len(msgs)-1]; last.Role != "assistant" || last.Content != anthropicPrefill {
+		t.Errorf("got last message %+v; want prefill", last)
+	}
+}
+
+func TestAnthropicMaxTokens(t *testing.T) {
+	anthropicReplayServer(t, "max_tokens.sse")
+	b, err := newAnthropicBackend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	text, _, err := collectEvents(t, b, testRequest())
+	if err == nil || !strings.Contains(err.Error(), "truncated") {
+		t.Fatalf("got error %v; want truncation error", err)
+	}
+	if want := `{"parts":[{"type":"commentary","text":"hello"}]}`; text != want {
+		t.Errorf("got text %q before error; want %q", text, want)
+	}
+}
+
+func TestAnthropicErrorEvent(t *testing.T) {
+	anthropicReplayServer(t, "error.sse")
+	b, err := newAnthropicBackend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	text, _, err := collectEvents(t, b, testRequest())
+	if err == nil || !strings.Contains(err.Error(), "overloaded_error: Overloaded") {
+		t.Fatalf("got error %v; want overloaded error", err)
+	}
+	if want := `{"parts":[`; text != want {
+		t.Errorf("got text %q before error; want %q", text, want)
+	}
+}
+
+func TestAnthropicToolUse(t *testing.T) {
+	reqs := anthropicReplayServer(t, "tool_use_1.sse", "tool_use_2.sse")
+	b, err := newAnthropicBackend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gotArgs string
+	req := testRequest()
+	req.Tools = []*Tool{{
+		Name:       "read_file",
+		Parameters: map[string]any{"type": "object"},
+		Call: func(args []byte) (string, error) {
+			gotArgs = string(args)
+			return "package x\n", nil
+		},
+	}}
+	text, usage, err := collectEvents(t, b, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The text before the tool call isn't part of the reply.
+	if want := `{"parts":[{"type":"commentary","text":"done"}]}`; text != want {
+		t.Errorf("got text %q; want %q", text, want)
+	}
+	if want := `{"path": "x.go"}`; gotArgs != want {
+		t.Errorf("tool called with %q; want %q", gotArgs, want)
+	}
+	if want := (Usage{InputTokens: 250, OutputTokens: 50}); usage == nil || *usage != want {
+		t.Errorf("got usage %+v; want %+v", usage, want)
+	}
+	if len(*reqs) != 2 {
+		t.Fatalf("got %d requests; want 2", len(*reqs))
+	}
+	// The second request must hold the tool call and its result.
+	msgs := (*reqs)[1].Messages
+	if len(msgs) != 3 {
+		t.Fatalf("got %d messages in second request; want 3", len(msgs))
+	}
+	data, err := json.Marshal(msgs[1:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []struct {
+		Role    string           `json:"role"`
+		Content []anthropicBlock `json:"content"`
+	}
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	assistant, user := got[0], got[1]
+	if assistant.Role != "assistant" || len(assistant.Content) != 2 {
+		t.Fatalf("got assistant message %+v; want text and tool_use blocks", assistant)
+	}
+	if call := assistant.Content[1]; call.Type != "tool_use" || call.ID != "toolu_01" || string(call.Input) != `{"path":"x.go"}` {
+		t.Errorf("got tool call %+v", call)
+	}
+	if user.Role != "user" || len(user.Content) != 1 {
+		t.Fatalf("got user message %+v; want a tool_result block", user)
+	}
+	if result := user.Content[0]; result.Type != "tool_result" || result.ToolUseID != "toolu_01" || result.Content != "package x\n" {
+		t.Errorf("got tool result %+v", result)
+	}
+}
+
+func TestAnthropicHTTPError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		http.Error(w, `{"type":"error","error":{"type":"authentication_error"}}`, http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+	t.Setenv("ANTHROPIC_API_KEY", "test-key")
+	t.Setenv("ANTHROPIC_BASE_URL", srv.URL)
+	b, err := newAnthropicBackend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = collectEvents(t, b, testRequest())
+	if err == nil || !strings.Contains(err.Error(), "authentication_error") {
+		t.Fatalf("got error %v; want authentication error", err)
+	}
+}
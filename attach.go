@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// attachment holds a file to be attached to a request.
+type attachment struct {
+	filename string
+	data     []byte
+}
+
+// droppedFile holds a file that was found by a pattern
+// but not attached.
+type droppedFile struct {
+	filename string
+	reason   string
+}
+
+// skippedDirs holds the names of directories holding code
+// that isn't part of the project.
+var skippedDirs = []string{"vendor", "node_modules"}
+
+// collectAttachments returns the files named by args, which
+// may name files, directories (for the files directly inside them),
+// directories followed by "/..." (for all the files inside them
+// recursively) or glob patterns.
+//
+// Files found via directories and patterns are skipped if they're
+// ignored by git, vendored or binary, and they're dropped when
+// their total size would exceed limit bytes, unless limit is
+// negative. Files named explicitly are always included.
+// The file named by exclude is never included.
+func collectAttachments(args []string, exclude string, limit int) ([]attachment, []droppedFile, error) {
+	var included []attachment
+	var dropped []droppedFile
+	// Names are compared in absolute form, because args
+	// may name the same file in different ways.
+	exclude, err := filepath.Abs(exclude)
+	if err != nil {
+		return nil, nil, err
+	}
+	seen := make(map[string]bool)
+	total := 0
+	for _, arg := range args {
+		names, explicit, err := expandArg(arg)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, name := range names {
+			abs, err := filepath.Abs(name)
+			if err != nil {
+				return nil, nil, err
+			}
+			if abs == exclude || seen[abs] {
+				continue
+			}
+			seen[abs] = true
+			data, err := os.ReadFile(name)
+			if err != nil {
+				if explicit {
+					return nil, nil, err
+				}
+				dropped = append(dropped, droppedFile{name, err.Error()})
+				continue
+			}
+			if !explicit {
+				if isBinary(data) {
+					dropped = append(dropped, droppedFile{name, "binary file"})
+					continue
+				}
+				if limit >= 0 && total+len(data) > limit {
+					dropped = append(dropped, droppedFile{name, fmt.Sprintf("%d bytes would exceed budget", len(data))})
+					continue
+				}
+			}
+			total += len(data)
+			included = append(included, attachment{name, data})
+		}
+	}
+	return included, dropped, nil
+}
+
+// expandArg returns the files named by the given argument
+// to collectAttachments. It reports whether the argument
+// names a single file explicitly.
+func expandArg(arg string) ([]string, bool, error) {
+	if dir, ok := strings.CutSuffix(arg, "/..."); ok {
+		names, err := listFiles(dir, true)
+		return names, false, err
+	}
+	if strings.ContainsAny(arg, "*?[") {
+		names, err := globNotIgnored(arg)
+		return names, false, err
+	}
+	info, err := os.Stat(arg)
+	if err != nil {
+		return nil, false, err
+	}
+	if info.IsDir() {
+		names, err := listFiles(arg, false)
+		return names, false, err
+	}
+	return []string{arg}, true, nil
+}
+
+// listFiles returns the files inside dir, recursively
+// if recursive is true, omitting any that are ignored by
+// git or inside a skipped directory.
+func listFiles(dir string, recursive bool) ([]string, error) {
+	rels, err := gitFiles(dir)
+	if err != nil {
+		// Not a git repository, or no git.
+		rels, err = walkFiles(dir)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var names []string
+	for _, rel := range rels {
+		if !recursive && strings.Contains(rel, "/") {
+			continue
+		}
+		if slices.ContainsFunc(strings.Split(path.Dir(rel), "/"), isSkippedDir) {
+			continue
+		}
+		names = append(names, filepath.Join(dir, filepath.FromSlash(rel)))
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+// globNotIgnored returns the regular files matching the
+// given pattern that aren't ignored by git.
+func globNotIgnored(pattern string) ([]string, error) {
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("bad pattern %q: %v", pattern, err)
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("no files match %q", pattern)
+	}
+	// notIgnored holds the files that aren't ignored
+	// in each directory, or nil if that's not known.
+	notIgnored := make(map[string]map[string]bool)
+	var names []string
+	for _, m := range matches {
+		if info, err := os.Stat(m); err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		dir := filepath.Dir(m)
+		files, ok := notIgnored[dir]
+		if !ok {
+			if rels, err := gitFiles(dir); err == nil {
+				files = make(map[string]bool)
+				for _, rel := range rels {
+					files[rel] = true
+				}
+			}
+			notIgnored[dir] = files
+		}
+		if files != nil && !files[filepath.Base(m)] {
+			continue
+		}
+		names = append(names, m)
+	}
+	return names, nil
+}
+
+// gitFiles returns the slash-separated names, relative to dir,
+// of all the files in dir and its subdirectories that are
+// tracked by git or untracked but not ignored.
+func gitFiles(dir string) ([]string, error) {
+	cmd := exec.Command("git", "ls-files", "-z", "--cached", "--others", "--exclude-standard")
+	cmd.Dir = dir
+	cmd.Stderr = io.Discard
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("cannot list files in %s: %v", dir, err)
+	}
+	var names []string
+	for name := range strings.SplitSeq(string(out), "\x00") {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	// Files with merge conflicts are listed more than once.
+	slices.Sort(names)
+	return slices.Compact(names), nil
+}
+
+// walkFiles returns the slash-separated names, relative
+// to dir, of all the regular files in dir and its
+// subdirectories, ignoring hidden directories.
+func walkFiles(dir string) ([]string, error) {
+	var names []string
+	err := filepath.WalkDir(dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if name != dir && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, name)
+		if err != nil {
+			return err
+		}
+		names = append(names, filepath.ToSlash(rel))
+		return nil
+	})
+	return names, err
+}
+
+func isSkippedDir(name string) bool {
+	return slices.Contains(skippedDirs, name)
+}
+
+// isBinary reports whether data looks like the
+// contents of a binary file.
+func isBinary(data []byte) bool {
+	return !utf8.Valid(data) || bytes.IndexByte(data, 0) >= 0
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+var collectAttachmentsTests = []struct {
+	name string
+	args []string
+	want []string
+}{{
+	name: "directory",
+	args: []string{"."},
+	want: []string{"b.go", "c.txt"},
+}, {
+	name: "pattern",
+	args: []string{"*.go"},
+	want: []string{"b.go"},
+}, {
+	name: "recursive",
+	args: []string{"./..."},
+	want: []string{"b.go", "c.txt", "sub/d.go"},
+}, {
+	name: "explicit",
+	args: []string{"a.go", "./a.go", "b.go"},
+	want: []string{"b.go"},
+}, {
+	name: "same file named differently",
+	args: []string{"b.go", "./b.go", "*.go", "sub/../b.go"},
+	want: []string{"b.go"},
+}}
+
+func TestCollectAttachments(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"a.go":     "package a\n",
+		"b.go":     "package a\n",
+		"c.txt":    "c\n",
+		"sub/d.go": "package sub\n",
+	} {
+		name = filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(name), 0o777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(content), 0o666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Chdir(dir)
+	// The file being edited is named by its absolute
+	// path, as acme does.
+	exclude := filepath.Join(dir, "a.go")
+	for _, test := range collectAttachmentsTests {
+		t.Run(test.name, func(t *testing.T) {
+			included, dropped, err := collectAttachments(test.args, exclude, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(dropped) > 0 {
+				t.Errorf("unexpected dropped files %v", dropped)
+			}
+			var got []string
+			for _, a := range included {
+				abs, err := filepath.Abs(a.filename)
+				if err != nil {
+					t.Fatal(err)
+				}
+				rel, err := filepath.Rel(dir, abs)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, filepath.ToSlash(rel))
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, test.want) {
+				t.Errorf("got %q; want %q", got, test.want)
+			}
+		})
+	}
+}
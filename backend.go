@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"slices"
+	"strings"
+)
+
+// Backend represents a model provider. The reply-part parser
+// and the apply engine only see the text that a Backend produces,
+// so they don't care which provider generated it.
+type Backend interface {
+	// Stream sends the given request to the model and returns
+	// the resulting sequence of events. The sequence
+	// ends after an event with Done set, or with an error.
+	Stream(ctx context.Context, req *Request) iter.Seq2[Event, error]
+}
+
+// Request holds a request to be sent to a model.
+type Request struct {
+	// Model holds the name of the model to use.
+	Model string
+
+	// System holds the system prompt.
+	System string
+
+	// Messages holds the conversation so far. It alternates
+	// between user and assistant messages, starting and
+	// ending with a user message.
+	Messages []Message
+
+	// Schema holds the JSON Schema that the reply must
+	// conform to. If it's nil, or the provider doesn't support
+	// structured output, the reply is only required to be JSON.
+	Schema map[string]any
+
+	// Tools holds any tools that the model may call
+	// before producing its reply.
+	Tools []*Tool
+
+	// Effort holds the reasoning effort to ask of the model:
+	// one of "low", "medium" or "high". If it's empty,
+	// the provider's default is used.
+	Effort string
+}
+
+// Message holds one message in a conversation.
+type Message struct {
+	// Role holds the role of the message's author,
+	// either "user" or "assistant".
+	Role string `json:"role"`
+
+	// Parts holds the parts making up a user message.
+	Parts []Part `json:"parts,omitempty"`
+
+	// Text holds the text of an assistant message.
+	Text string `json:"text,omitempty"`
+}
+
+// Event holds one event in the stream of events
+// produced by a Backend.
+type Event struct {
+	// Text holds a fragment of the reply text.
+	Text string
+
+	// Reasoning holds a fragment of the summary of
+	// the model's reasoning, if it provides one.
+	// It is not part of the reply.
+	Reasoning string
+
+	// Usage holds the token usage for the request.
+	// It is only set when Done is true.
+	Usage *Usage
+
+	// Done is set on the final event of a successfully
+	// completed reply.
+	Done bool
+}
+
+// Usage holds token usage as reported by the provider.
+type Usage struct {
+	InputTokens     int64 `json:"input"`
+	CachedTokens    int64 `json:"cached,omitempty"`
+	OutputTokens    int64 `json:"output"`
+	ReasoningTokens int64 `json:"reasoning,omitempty"`
+}
+
+// add adds the token counts in u1 to u.
+func (u *Usage) add(u1 Usage) {
+	u.InputTokens += u1.InputTokens
+	u.CachedTokens += u1.CachedTokens
+	u.OutputTokens += u1.OutputTokens
+	u.ReasoningTokens += u1.ReasoningTokens
+}
+
+// Providers and their default models. The "chat"
+// provider talks to OpenAI-compatible servers that
+// implement the chat completions API, and has no default
+// model because it depends on the server.
+var defaultModels = map[string]string{
+	"openai":    "gpt-4o",
+	"anthropic": "claude-sonnet-4-5",
+}
+
+// efforts holds the valid values for Request.Effort.
+var efforts = []string{"low", "medium", "high"}
+
+// isReasoningModel reports whether the given OpenAI model
+// is a reasoning model.
+func isReasoningModel(model string) bool {
+	for _, prefix := range []string{"o1", "o3", "o4", "gpt-5"} {
+		if strings.HasPrefix(model, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// modelProvider returns the provider for the given model.
+// If provider is non-empty, it's returned unchanged.
+// Custom servers are assumed to be local servers,
+// which rarely implement the Responses API.
+func modelProvider(provider, model, baseURL string) string {
+	switch {
+	case provider != "":
+		return provider
+	case strings.HasPrefix(model, "claude"):
+		return "anthropic"
+	case baseURL != "":
+		return "chat"
+	}
+	return "openai"
+}
+
+// newBackend returns the backend for the given provider.
+// If baseURL is non-empty, it specifies the API endpoint
+// of an OpenAI-compatible server.
+func newBackend(provider, baseURL string) (Backend, error) {
+	switch provider {
+	case "openai":
+		return newOpenAIBackend(baseURL)
+	case "chat":
+		return newChatBackend(baseURL)
+	case "anthropic":
+		return newAnthropicBackend()
+	}
+	return nil, fmt.Errorf("unknown provider %q", provider)
+}
+
+// mediaTypes holds the media types of content that is
+// sent to models natively rather than as text.
+var mediaTypes = []string{
+	"application/pdf",
+	"image/gif",
+	"image/jpeg",
+	"image/png",
+	"image/webp",
+}
+
+// messageText returns the text of the given message.
+// The text of a user message holds all its parts, one per line,
+// with media parts represented by their descriptions.
+func messageText(m Message) (string, error) {
+	if m.Role == "assistant" {
+		return m.Text, nil
+	}
+	var buf strings.Builder
+	err := userContent(m, func(text string) {
+		buf.WriteString(text)
+		buf.WriteString("\n")
+	}, func(Part) {})
+	return buf.String(), err
+}
+
+// userContent calls text with the text of each part of the
+// user message m, in order, so that each part can be sent as
+// a separate content item. Media parts are described
+// to text, without their content, and then passed to media.
+func userContent(m Message, text func(string), media func(Part)) error {
+	for _, p := range m.Parts {
+		desc := p
+		if p.MediaType != "" {
+			desc.Content, desc.Base64 = "", false
+		}
+		t, err := desc.text()
+		if err != nil {
+			return fmt.Errorf("cannot marshal part: %v", err)
+		}
+		text(t)
+		if p.MediaType != "" {
+			media(p)
+		}
+	}
+	return nil
+}
+
+// hasMedia reports whether the message m
+// holds any media parts.
+func hasMedia(m Message) bool {
+	return slices.ContainsFunc(m.Parts, func(p Part) bool {
+		return p.MediaType != ""
+	})
+}
+
+// dataURL returns the content of the media part p as a data URL.
+func dataURL(p Part) string {
+	return "data:" + p.MediaType + ";base64," + p.Content
+}
+
+// skipToJSON returns events with any reply text before
+// the first '{' removed. Models that aren't constrained to
+// produce JSON are prone to preceding it with chatter
+// or code fences.
+func skipToJSON(events iter.Seq2[Event, error]) iter.Seq2[Event, error] {
+	return func(yield func(Event, error) bool) {
+		skipped := false
+		for ev, err := range events {
+			if err == nil && !skipped {
+				i := strings.Index(ev.Text, "{")
+				if i < 0 {
+					ev.Text = ""
+				} else {
+					ev.Text, skipped = ev.Text[i:], true
+				}
+				if ev == (Event{}) {
+					continue
+				}
+			}
+			if !yield(ev, err) {
+				return
+			}
+		}
+	}
+}
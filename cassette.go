@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"time"
+)
+
+// cassetteEvent holds a recorded Event. A cassette file
+// holds a sequence of these, one JSON object per line.
+type cassetteEvent struct {
+	// Time holds the time since the start of the stream
+	// in milliseconds.
+	Time      int64  `json:"t"`
+	Text      string `json:"text,omitempty"`
+	Reasoning string `json:"reasoning,omitempty"`
+	Usage     *Usage `json:"usage,omitempty"`
+	Done      bool   `json:"done,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// recordingBackend is a Backend that records all the
+// events produced by another Backend.
+type recordingBackend struct {
+	backend Backend
+	w       io.Writer
+}
+
+// newRecordingBackend returns a Backend that writes all the
+// events produced by b to w in cassette format.
+func newRecordingBackend(b Backend, w io.Writer) *recordingBackend {
+	return &recordingBackend{
+		backend: b,
+		w:       w,
+	}
+}
+
+func (b *recordingBackend) Stream(ctx context.Context, req *Request) iter.Seq2[Event, error] {
+	return func(yield func(Event, error) bool) {
+		enc := json.NewEncoder(b.w)
+		t0 := time.Now()
+		for ev, err := range b.backend.Stream(ctx, req) {
+			cev := cassetteEvent{
+				Time:      time.Since(t0).Milliseconds(),
+				Text:      ev.Text,
+				Reasoning: ev.Reasoning,
+				Usage:     ev.Usage,
+				Done:      ev.Done,
+			}
+			if err != nil {
+				cev.Error = err.Error()
+			}
+			if err := enc.Encode(cev); err != nil {
+				yield(Event{}, fmt.Errorf("cannot record event: %v", err))
+				return
+			}
+			if !yield(ev, err) {
+				return
+			}
+		}
+	}
+}
+
+// replayBackend is a Backend that replays events from
+// a previously recorded cassette instead of calling a model.
+// Each call to Stream replays the next recorded stream.
+type replayBackend struct {
+	dec *json.Decoder
+}
+
+// newReplayBackend returns a Backend that replays
+// the cassette read from r.
+func newReplayBackend(r io.Reader) *replayBackend {
+	return &replayBackend{
+		dec: json.NewDecoder(r),
+	}
+}
+
+func (b *replayBackend) Stream(ctx context.Context, req *Request) iter.Seq2[Event, error] {
+	return func(yield func(Event, error) bool) {
+		for {
+			var cev cassetteEvent
+			if err := b.dec.Decode(&cev); err != nil {
+				if errors.Is(err, io.EOF) {
+					err = fmt.Errorf("cassette ended before end of stream")
+				}
+				yield(Event{}, err)
+				return
+			}
+			if cev.Error != "" {
+				yield(Event{}, errors.New(cev.Error))
+				return
+			}
+			ev := Event{
+				Text:      cev.Text,
+				Reasoning: cev.Reasoning,
+				Usage:     cev.Usage,
+				Done:      cev.Done,
+			}
+			if !yield(ev, nil) || ev.Done {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func allowAll(string) bool { return true }
+
+var replayTests = []struct {
+	cassette string
+	body     bodyInfo
+
+	// want holds the expected text of the body
+	// after each stream in the cassette.
+	want []string
+
+	// wantErr holds a string that the error from
+	// the last stream must contain, if any.
+	wantErr string
+}{{
+	cassette: "replace.cassette",
+	body: bodyInfo{
+		head:      []byte("package p\n\n"),
+		selection: []byte("func f() {}\n"),
+		tail:      []byte("\nfunc g() {}\n"),
+	},
+	want: []string{
+		"package p\n\nfunc h() {\n\treturn\n}\n\nfunc g() {}\n",
+	},
+}, {
+	cassette: "error.cassette",
+	body: bodyInfo{
+		head:      []byte("package p\n\n"),
+		selection: []byte("func f() {}\n"),
+	},
+	// The edit before the error has been applied.
+	want: []string{
+		"package p\n\n// f does nothing.\nfunc f() {}\n",
+	},
+	wantErr: "INTERNAL_ERROR",
+}, {
+	// A cassette recorded with -check, where the first
+	// edit failed the checks and the model was asked to
+	// fix it.
+	cassette: "retry.cassette",
+	body: bodyInfo{
+		head:      []byte("package p\n\n"),
+		selection: []byte("func f() {}\n"),
+		tail:      []byte("\nvar s string = f()\n"),
+	},
+	want: []string{
+		"package p\n\nfunc f() int {\n\treturn \"x\"\n}\n\nvar s string = f()\n",
+		"package p\n\nfunc f() string {\n\treturn \"x\"\n}\n\nvar s string = f()\n",
+	},
+}}
+
+func TestReplayCassette(t *testing.T) {
+	for _, test := range replayTests {
+		t.Run(test.cassette, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", test.cassette))
+			if err != nil {
+				t.Fatal(err)
+			}
+			backend := newReplayBackend(bytes.NewReader(data))
+			body := test.body
+			body.text = slices.Concat(body.head, body.selection, body.tail)
+			var applied []byte
+			apply := func(oldText, newText []byte) error {
+				if !bytes.Equal(oldText, body.text) {
+					t.Errorf("apply called with old text %q; want %q", oldText, body.text)
+				}
+				applied = newText
+				return nil
+			}
+			for i, want := range test.want {
+				_, _, err := streamReply(backend.Stream(context.Background(), &Request{}), &body, allowAll, nil, apply)
+				if i == len(test.want)-1 && test.wantErr != "" {
+					if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+						t.Errorf("got error %v; want error containing %q", err, test.wantErr)
+					}
+				} else if err != nil {
+					t.Fatalf("stream %d: %v", i, err)
+				}
+				if string(body.text) != want {
+					t.Errorf("stream %d: got body %q; want %q", i, body.text, want)
+				}
+				if string(applied) != want {
+					t.Errorf("stream %d: got applied text %q; want %q", i, applied, want)
+				}
+			}
+			// There's nothing left in the cassette.
+			for _, err := range backend.Stream(context.Background(), &Request{}) {
+				if err == nil || !strings.Contains(err.Error(), "cassette ended") {
+					t.Errorf("got %v after last stream; want end of cassette", err)
+				}
+				break
+			}
+		})
+	}
+}
+
+func TestRecordReplay(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "retry.cassette"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Recording a replayed cassette produces the same
+	// events, apart from the times.
+	var buf bytes.Buffer
+	backend := newRecordingBackend(newReplayBackend(bytes.NewReader(data)), &buf)
+	for range 2 {
+		for _, err := range backend.Stream(context.Background(), &Request{}) {
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	replay := newReplayBackend(&buf)
+	orig := newReplayBackend(bytes.NewReader(data))
+	for range 2 {
+		var got, want []Event
+		for ev, err := range replay.Stream(context.Background(), &Request{}) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, ev)
+		}
+		for ev := range orig.Stream(context.Background(), &Request{}) {
+			want = append(want, ev)
+		}
+		if len(got) != len(want) {
+			t.Fatalf("got %d events; want %d", len(got), len(want))
+		}
+		for i := range got {
+			if got[i].Text != want[i].Text || got[i].Done != want[i].Done || (got[i].Usage == nil) != (want[i].Usage == nil) {
+				t.Errorf("event %d: got %+v; want %+v", i, got[i], want[i])
+			}
+		}
+	}
+}
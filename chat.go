@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"iter"
+	"path/filepath"
+	"strings"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/shared"
+)
+
+// chatBackend implements Backend using the OpenAI chat completions API.
+// Unlike the Responses API, this is implemented by many
+// OpenAI-compatible local servers, such as llama.cpp and Ollama.
+type chatBackend struct {
+	client openai.Client
+
+	// noSchema records that the server has rejected
+	// our request for structured output.
+	noSchema bool
+
+	// noTools records that the server has rejected
+	// our request to use tools.
+	noTools bool
+
+	// noJSONMode records that the server has rejected
+	// our request for JSON output.
+	noJSONMode bool
+}
+
+// jsonOnlyPrompt is added to the system prompt because
+// many servers ignore or reject the request for JSON output.
+const jsonOnlyPrompt = `
+Reply with a single JSON object only, with no surrounding text
+and no markdown code fences.
+`
+
+// newChatBackend returns a backend that talks to the
+// chat completions endpoint at the given base URL.
+// If baseURL is empty, the default OpenAI endpoint is used.
+func newChatBackend(baseURL string) (*chatBackend, error) {
+	opts, err := openaiOptions(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	return &chatBackend{
+		client: openai.NewClient(opts...),
+	}, nil
+}
+
+func (b *chatBackend) Stream(ctx context.Context, req *Request) iter.Seq2[Event, error] {
+	return skipToJSON(withFallback(func() iter.Seq2[Event, error] {
+		return b.stream(ctx, req)
+	}, func() bool {
+		// The server might not understand response_format
+		// or tools, so fall back to JSON mode and then to
+		// relying on the prompt alone to get us some JSON.
+		switch {
+		case req.Schema != nil && !b.noSchema:
+			b.noSchema = true
+		case len(req.Tools) > 0 && !b.noTools:
+			b.noTools = true
+		case !b.noJSONMode:
+			b.noJSONMode = true
+		default:
+			return false
+		}
+		return true
+	}))
+}
+
+func (b *chatBackend) stream(ctx context.Context, req *Request) iter.Seq2[Event, error] {
+	return func(yield func(Event, error) bool) {
+		params, err := b.params(req)
+		if err != nil {
+			yield(Event{}, err)
+			return
+		}
+		var usage Usage
+		for round := 0; ; round++ {
+			var calls []openai.ChatCompletionMessageToolCallParam
+			for chunk, err := range streamIter(b.client.Chat.Completions.NewStreaming(ctx, params)) {
+				if err != nil {
+					yield(Event{}, err)
+					return
+				}
+				if chunk.JSON.Usage.IsPresent() {
+					u := chunk.Usage
+					usage.add(Usage{
+						InputTokens:     u.PromptTokens,
+						CachedTokens:    u.PromptTokensDetails.CachedTokens,
+						OutputTokens:    u.CompletionTokens,
+						ReasoningTokens: u.CompletionTokensDetails.ReasoningTokens,
+					})
+				}
+				if len(chunk.Choices) == 0 {
+					continue
+				}
+				choice := chunk.Choices[0]
+				if choice.FinishReason == "length" {
+					yield(Event{}, fmt.Errorf("reply truncated: maximum token count reached"))
+					return
+				}
+				// Tool calls arrive in fragments, identified by index.
+				for _, tc := range choice.Delta.ToolCalls {
+					for int(tc.Index) >= len(calls) {
+						calls = append(calls, openai.ChatCompletionMessageToolCallParam{})
+					}
+					call := &calls[tc.Index]
+					if tc.ID != "" {
+						call.ID = tc.ID
+					}
+					call.Function.Name += tc.Function.Name
+					call.Function.Arguments += tc.Function.Arguments
+				}
+				if r := reasoningContent(choice.Delta); r != "" {
+					if !yield(Event{Reasoning: r}, nil) {
+						return
+					}
+				}
+				if choice.Delta.Content == "" {
+					continue
+				}
+				if !yield(Event{Text: choice.Delta.Content}, nil) {
+					return
+				}
+			}
+			if len(calls) == 0 {
+				yield(Event{
+					Done:  true,
+					Usage: &usage,
+				}, nil)
+				return
+			}
+			if round >= maxToolRounds {
+				yield(Event{}, fmt.Errorf("too many rounds of tool calls"))
+				return
+			}
+			params.Messages = append(params.Messages, openai.ChatCompletionMessageParamUnion{
+				OfAssistant: &openai.ChatCompletionAssistantMessageParam{
+					ToolCalls: calls,
+				},
+			})
+			for _, call := range calls {
+				params.Messages = append(params.Messages, openai.ToolMessage(
+					callTool(req.Tools, call.Function.Name, call.Function.Arguments),
+					call.ID,
+				))
+			}
+		}
+	}
+}
+
+func (b *chatBackend) params(req *Request) (openai.ChatCompletionNewParams, error) {
+	params := openai.ChatCompletionNewParams{
+		Model: shared.ChatModel(req.Model),
+		StreamOptions: openai.ChatCompletionStreamOptionsParam{
+			IncludeUsage: openai.Bool(true),
+		},
+	}
+	if req.Effort != "" {
+		params.ReasoningEffort = shared.ReasoningEffort(req.Effort)
+	}
+	switch {
+	case req.Schema != nil && !b.noSchema:
+		params.ResponseFormat.OfJSONSchema = &shared.ResponseFormatJSONSchemaParam{
+			JSONSchema: shared.ResponseFormatJSONSchemaJSONSchemaParam{
+				Name:   "reply",
+				Schema: req.Schema,
+				Strict: openai.Bool(true),
+			},
+		}
+	case !b.noJSONMode:
+		params.ResponseFormat.OfJSONObject = &shared.ResponseFormatJSONObjectParam{}
+	}
+	if !b.noTools {
+		for _, t := range req.Tools {
+			params.Tools = append(params.Tools, openai.ChatCompletionToolParam{
+				Function: shared.FunctionDefinitionParam{
+					Name:        t.Name,
+					Description: openai.String(t.Description),
+					Parameters:  t.Parameters,
+					Strict:      openai.Bool(true),
+				},
+			})
+		}
+	}
+	params.Messages = []openai.ChatCompletionMessageParamUnion{
+		openai.SystemMessage(req.System + jsonOnlyPrompt),
+	}
+	for _, m := range req.Messages {
+		if m.Role == "assistant" {
+			params.Messages = append(params.Messages, openai.AssistantMessage(m.Text))
+			continue
+		}
+		if !hasMedia(m) {
+			// Some servers only understand plain text content.
+			text, err := messageText(m)
+			if err != nil {
+				return openai.ChatCompletionNewParams{}, err
+			}
+			params.Messages = append(params.Messages, openai.UserMessage(text))
+			continue
+		}
+		content, err := chatContent(m)
+		if err != nil {
+			return openai.ChatCompletionNewParams{}, err
+		}
+		params.Messages = append(params.Messages, openai.UserMessage(content))
+	}
+	return params, nil
+}
+
+// chatContent returns the content of the user message m,
+// with images as image_url parts and PDF documents
+// as file parts.
+func chatContent(m Message) ([]openai.ChatCompletionContentPartUnionParam, error) {
+	var content []openai.ChatCompletionContentPartUnionParam
+	err := userContent(m, func(text string) {
+		content = append(content, openai.TextContentPart(text))
+	}, func(p Part) {
+		if strings.HasPrefix(p.MediaType, "image/") {
+			content = append(content, openai.ImageContentPart(openai.ChatCompletionContentPartImageImageURLParam{
+				URL: dataURL(p),
+			}))
+			return
+		}
+		content = append(content, openai.FileContentPart(openai.ChatCompletionContentPartFileFileParam{
+			Filename: openai.String(filepath.Base(p.Filename)),
+			FileData: openai.String(dataURL(p)),
+		}))
+	})
+	return content, err
+}
+
+// reasoningContent returns any reasoning text in the given delta.
+// This isn't part of the OpenAI API, but servers for
+// open reasoning models, such as llama.cpp and vLLM,
+// commonly provide it.
+func reasoningContent(delta openai.ChatCompletionChunkChoiceDelta) string {
+	f, ok := delta.JSON.ExtraFields["reasoning_content"]
+	if !ok {
+		return ""
+	}
+	var text string
+	if err := json.Unmarshal([]byte(f.Raw()), &text); err != nil {
+		return ""
+	}
+	return text
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// chatReplayServer starts a server for the chat completions API
+// that, like many OpenAI-compatible servers, rejects requests
+// that use response_format or tools, and otherwise replies with
+// the named SSE stream in testdata/chat. It returns the URL of
+// the server and a pointer to the bodies of the requests received.
+func chatReplayServer(t *testing.T, stream string) (string, *[]map[string]any) {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", "chat", stream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reqs []map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/chat/completions" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		var body map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		reqs = append(reqs, body)
+		for _, field := range []string{"response_format", "tools"} {
+			if _, ok := body[field]; ok {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]any{
+					"error": map[string]any{
+						"message": "unsupported field " + field,
+						"type":    "invalid_request_error",
+					},
+				})
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write(data)
+	}))
+	t.Cleanup(srv.Close)
+	t.Setenv("OPENAI_API_KEY", "")
+	return srv.URL + "/", &reqs
+}
+
+// requestFeatures returns a description of the JSON-mode
+// features used by the given chat completions request.
+func requestFeatures(body map[string]any) []string {
+	var features []string
+	if rf, ok := body["response_format"].(map[string]any); ok {
+		features = append(features, rf["type"].(string))
+	}
+	if _, ok := body["tools"]; ok {
+		features = append(features, "tools")
+	}
+	return features
+}
+
+func TestChatFallback(t *testing.T) {
+	url, reqs := chatReplayServer(t, "chatter.sse")
+	b, err := newChatBackend(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := testRequest()
+	req.Model = "qwen3-coder"
+	req.Schema = map[string]any{"type": "object"}
+	req.Tools = []*Tool{{
+		Name:       "read_file",
+		Parameters: map[string]any{"type": "object"},
+		Call: func(args []byte) (string, error) {
+			t.Errorf("unexpected tool call")
+			return "", nil
+		},
+	}}
+	for i := range 2 {
+		var saved bytes.Buffer
+		var got []string
+		for part, err := range partsIter(b.Stream(context.Background(), req), &saved) {
+			if err != nil {
+				t.Fatalf("response %q: %v", saved.String(), err)
+			}
+			got = append(got, partString(part))
+		}
+		want := []string{"commentary:renamed", "selectionReplace:func h() {}\n"}
+		if !slices.Equal(got, want) {
+			t.Errorf("request %d: got parts %q; want %q", i, got, want)
+		}
+		// The chatter before the JSON is skipped.
+		if !strings.HasPrefix(saved.String(), `{"parts":`) {
+			t.Errorf("request %d: got reply text %q", i, saved.String())
+		}
+	}
+	// The features are dropped one at a time until the
+	// server accepts the request. The backend remembers
+	// what the server rejected, so the second request
+	// succeeds straight away.
+	want := [][]string{
+		{"json_schema", "tools"},
+		{"json_object", "tools"},
+		{"json_object"},
+		nil,
+		nil,
+	}
+	if len(*reqs) != len(want) {
+		t.Fatalf("got %d requests; want %d", len(*reqs), len(want))
+	}
+	for i, body := range *reqs {
+		if got := requestFeatures(body); !slices.Equal(got, want[i]) {
+			t.Errorf("request %d: got features %q; want %q", i, got, want[i])
+		}
+		// The prompt asks for JSON in every case.
+		msgs := body["messages"].([]any)
+		system := msgs[0].(map[string]any)
+		if system["role"] != "system" || !strings.Contains(system["content"].(string), strings.TrimSpace(jsonOnlyPrompt)) {
+			t.Errorf("request %d: got system message %v", i, system)
+		}
+	}
+}
+
+func TestChatFallbackGivesUp(t *testing.T) {
+	// The server rejects every request.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":{"message":"no","type":"invalid_request_error"}}`))
+	}))
+	defer srv.Close()
+	t.Setenv("OPENAI_API_KEY", "")
+	b, err := newChatBackend(srv.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gotErr error
+	for _, err := range b.Stream(context.Background(), testRequest()) {
+		if err != nil {
+			gotErr = err
+		}
+	}
+	if gotErr == nil || !isBadRequest(gotErr) {
+		t.Errorf("got error %v; want bad request", gotErr)
+	}
+	if !b.noJSONMode {
+		t.Errorf("JSON mode not dropped")
+	}
+}
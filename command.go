@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"time"
+)
+
+// commandTimeout holds the maximum time that
+// a command run with -cmd may take.
+const commandTimeout = 5 * time.Minute
+
+// commandPart runs the given shell command in dir and returns
+// a part holding the command line, its combined standard output
+// and standard error, and its exit status. A command that fails
+// isn't an error. If limit is non-negative, only the last
+// limit bytes of the output are included.
+func commandPart(dir, command string, limit int) (Part, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	var status string
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		status = "exit status 0"
+	case ctx.Err() != nil:
+		status = fmt.Sprintf("killed after %v", commandTimeout)
+	case errors.As(err, &exitErr):
+		status = exitErr.String()
+	default:
+		return Part{}, fmt.Errorf("cannot run %q: %v", command, err)
+	}
+	if limit >= 0 && len(out) > limit {
+		out = fmt.Appendf(nil, "[%d bytes omitted]\n%s", len(out)-limit, out[len(out)-limit:])
+	}
+	if len(out) > 0 && out[len(out)-1] != '\n' {
+		out = append(out, '\n')
+	}
+	return Part{
+		Instructions: "This part holds the output of a command run in the directory of the file being edited: the command line, its combined standard output and standard error, and its exit status.",
+		Content:      fmt.Sprintf("$ %s\n%s(%s)\n", command, out, status),
+	}, nil
+}
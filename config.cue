@@ -0,0 +1,96 @@
+package main
+
+// #Config describes a configuration file. Configuration
+// is read from $HOME/.config/AI/config.cue and from
+// .ai/config.cue in the directory of the edited file
+// and all its parents, with files nearer the edited file
+// taking precedence. JSON files named config.json
+// are also accepted.
+#Config: {
+	// provider holds the default model provider.
+	provider?: "openai" | "chat" | "anthropic"
+
+	// model holds the default model.
+	model?: string
+
+	// baseURL holds the base URL of an OpenAI-compatible
+	// API server.
+	baseURL?: string
+
+	// budget holds the token budget for a request.
+	budget?: int & >0
+
+	// system holds text to be added to the system prompt.
+	system?: string
+
+	// extensions holds settings for files with particular
+	// extensions, keyed by extension, for example ".go".
+	extensions?: [string]: #ExtensionConfig
+
+	// context holds rules for attaching files as context.
+	context?: [...#ContextRule]
+
+	// presets holds named presets, invoked with -p.
+	presets?: [string]: #Preset
+
+	// sensitive holds glob patterns for files that must never
+	// be sent to the model, matched against file names as
+	// #ContextRule.match is. Editing such a file is refused,
+	// and such files are left out of any context.
+	sensitive?: [...string]
+}
+
+// #ExtensionConfig holds settings for files with
+// a particular extension. They take precedence over
+// the settings in #Config.
+#ExtensionConfig: {
+	// model holds the model to use.
+	model?: string
+
+	// system holds text to be added to the system prompt.
+	system?: string
+
+	// checks holds shell commands used to check edits,
+	// as if specified with -check.
+	checks?: [...string]
+}
+
+// #ContextRule describes files to attach when
+// editing particular files.
+#ContextRule: {
+	// match holds a glob pattern matched against the name
+	// of the edited file. If it contains a slash, it's matched
+	// against the path relative to the directory containing
+	// the .ai directory; otherwise it's matched against
+	// the last element of the name.
+	match!: string
+
+	// attach holds glob patterns for the files to attach,
+	// relative to the directory containing the .ai directory.
+	attach!: [...string]
+}
+
+// #Preset holds a named set of instructions and
+// associated settings.
+#Preset: {
+	// prompt holds a Go text/template that produces the
+	// instructions. The template is executed with the fields
+	// Filename (the name of the edited file), Language
+	// (its programming language, if known) and Selection
+	// (the selected text).
+	prompt!: string
+
+	// model holds the model to use.
+	model?: string
+
+	// attach holds glob patterns for files to attach,
+	// relative to the directory containing the .ai directory.
+	attach?: [...string]
+
+	// replyTypes holds the types of reply part that the
+	// model may use. If it's empty, all types are allowed.
+	replyTypes?: [...#ReplyType]
+}
+
+// #ReplyType holds the type of a reply part.
+#ReplyType: "commentary" | "entire" | "selectionAppend" | "selectionReplace" | "selectionInsert" | "instruction"
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"cmp"
+	_ "embed"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/cuecontext"
+)
+
+//go:embed config.cue
+var configCUE string
+
+// configNames holds the names of the files that
+// can hold configuration, in order of preference.
+var configNames = []string{"config.cue", "config.json"}
+
+// applyConfig sets the flags that weren't specified on the
+// command line from the settings in cfg and preset
+// for the given file.
+func applyConfig(cfg *Config, preset *Preset, filename string) {
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	ext := cfg.forFile(filename)
+	if !set["m"] {
+		*flagModel = cmp.Or(preset.Model, ext.Model, cfg.Model)
+		// The provider goes with the model in the configuration;
+		// for other models it's inferred from the model name.
+		if !set["provider"] && preset.Model == "" && ext.Model == "" {
+			*flagProvider = cfg.Provider
+		}
+	}
+	if *flagURL == "" {
+		*flagURL = cfg.BaseURL
+	}
+	if !set["budget"] && cfg.Budget > 0 {
+		*flagBudget = int(cfg.Budget)
+	}
+	if !set["check"] {
+		flagChecks = ext.Checks
+	}
+}
+
+// loadConfig returns the configuration that applies to
+// the given file, merged from all the configuration files
+// in the directories returned by configDirs.
+// It also returns the names of the files that were read.
+func loadConfig(filename string) (*Config, []string, error) {
+	schema, err := configSchema()
+	if err != nil {
+		return nil, nil, err
+	}
+	var cfg Config
+	var files []string
+	for _, dir := range configDirs(filename) {
+		path, data, err := readConfigFile(dir)
+		if err != nil {
+			return nil, nil, err
+		}
+		if data == nil {
+			continue
+		}
+		c, err := parseConfig(schema, path, data)
+		if err != nil {
+			return nil, nil, err
+		}
+		c.resolve(filepath.Dir(dir))
+		cfg.merge(c)
+		files = append(files, path)
+	}
+	return &cfg, files, nil
+}
+
+// configDirs returns the directories that can hold configuration
+// for the given file, outermost first: the user's configuration
+// directory followed by each .ai directory in the
+// directory containing the file and its parents.
+func configDirs(filename string) []string {
+	var dirs []string
+	for d := filepath.Dir(filename); ; {
+		dirs = append(dirs, filepath.Join(d, ".ai"))
+		parent := filepath.Dir(d)
+		if parent == d {
+			break
+		}
+		d = parent
+	}
+	if dir, err := os.UserConfigDir(); err == nil {
+		dirs = append(dirs, filepath.Join(dir, "AI"))
+	}
+	slices.Reverse(dirs)
+	return dirs
+}
+
+// readConfigFile returns the name and contents of the
+// configuration file in dir. It returns nil data
+// if there is none.
+func readConfigFile(dir string) (string, []byte, error) {
+	for _, name := range configNames {
+		path := filepath.Join(dir, name)
+		data, err := os.ReadFile(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return "", nil, fmt.Errorf("cannot read configuration: %v", err)
+		}
+		return path, data, nil
+	}
+	return "", nil, nil
+}
+
+// systemPromptFor returns the system prompt to use when
+// editing filename, starting with the built-in prompt.
+// A system-override.md file in any of the directories returned
+// by configDirs replaces the prompt so far, and
+// a system.md file is appended to it.
+// It also returns the names of the files that were used.
+func systemPromptFor(filename string) (string, []string, error) {
+	prompt := systemPrompt
+	var files []string
+	for _, dir := range configDirs(filename) {
+		for _, name := range []string{"system-override.md", "system.md"} {
+			path := filepath.Join(dir, name)
+			data, err := os.ReadFile(path)
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return "", nil, fmt.Errorf("cannot read system prompt: %v", err)
+			}
+			if name == "system.md" {
+				prompt = joinText(prompt, string(data))
+			} else {
+				prompt = string(data)
+			}
+			files = append(files, path)
+		}
+	}
+	return prompt, files, nil
+}
+
+// configSchema returns the #Config definition.
+func configSchema() (cue.Value, error) {
+	v := cuecontext.New().CompileString(configCUE)
+	if err := v.Err(); err != nil {
+		return cue.Value{}, fmt.Errorf("cannot compile configuration schema: %v", err)
+	}
+	return v.LookupPath(cue.ParsePath("#Config")), nil
+}
+
+// parseConfig parses the configuration in data, read from
+// the named file, and checks it against schema.
+func parseConfig(schema cue.Value, path string, data []byte) (*Config, error) {
+	v := schema.Context().CompileBytes(data, cue.Filename(path))
+	if err := v.Err(); err != nil {
+		return nil, fmt.Errorf("cannot parse configuration: %v", err)
+	}
+	v = schema.Unify(v)
+	if err := v.Validate(cue.Concrete(true)); err != nil {
+		return nil, fmt.Errorf("invalid configuration in %s: %v", path, err)
+	}
+	var cfg Config
+	if err := v.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("cannot decode configuration in %s: %v", path, err)
+	}
+	for _, pat := range cfg.Sensitive {
+		if _, err := filepath.Match(pat, ""); err != nil {
+			return nil, fmt.Errorf("bad sensitive file pattern %q in %s: %v", pat, path, err)
+		}
+	}
+	return &cfg, nil
+}
+
+// resolve makes the file names in the context rules and
+// sensitive file patterns of c relative to dir rather than
+// to the directory containing the .ai directory.
+func (c *Config) resolve(dir string) {
+	for i := range c.Context {
+		r := &c.Context[i]
+		if strings.Contains(r.Match, "/") {
+			r.Match = filepath.Join(dir, filepath.FromSlash(r.Match))
+		}
+		resolvePatterns(dir, r.Attach)
+	}
+	for _, p := range c.Presets {
+		resolvePatterns(dir, p.Attach)
+	}
+	for i, pat := range c.Sensitive {
+		if strings.Contains(pat, "/") {
+			c.Sensitive[i] = filepath.Join(dir, filepath.FromSlash(pat))
+		}
+	}
+}
+
+// resolvePatterns makes each of the given
+// file name patterns relative to dir.
+func resolvePatterns(dir string, pats []string) {
+	for i, pat := range pats {
+		pats[i] = filepath.Join(dir, filepath.FromSlash(pat))
+	}
+}
+
+// merge merges the configuration in c1 into c.
+// Settings in c1 take precedence, except for system prompt
+// text, context rules and sensitive file patterns,
+// which are added to those in c.
+func (c *Config) merge(c1 *Config) {
+	c.Provider = cmp.Or(c1.Provider, c.Provider)
+	c.Model = cmp.Or(c1.Model, c.Model)
+	c.BaseURL = cmp.Or(c1.BaseURL, c.BaseURL)
+	c.Budget = cmp.Or(c1.Budget, c.Budget)
+	c.System = joinText(c.System, c1.System)
+	for ext, e1 := range c1.Extensions {
+		if c.Extensions == nil {
+			c.Extensions = make(map[string]ExtensionConfig)
+		}
+		e := c.Extensions[ext]
+		e.Model = cmp.Or(e1.Model, e.Model)
+		e.System = joinText(e.System, e1.System)
+		if e1.Checks != nil {
+			e.Checks = e1.Checks
+		}
+		c.Extensions[ext] = e
+	}
+	c.Context = append(c.Context, c1.Context...)
+	c.Sensitive = append(c.Sensitive, c1.Sensitive...)
+	for name, p := range c1.Presets {
+		if c.Presets == nil {
+			c.Presets = make(map[string]Preset)
+		}
+		c.Presets[name] = p
+	}
+}
+
+// forFile returns the settings in c that apply to files with
+// the extension of filename, if any.
+func (c *Config) forFile(filename string) ExtensionConfig {
+	return c.Extensions[filepath.Ext(filename)]
+}
+
+// sensitive reports whether the named file matches
+// any of the sensitive file patterns in c.
+func (c *Config) sensitive(filename string) bool {
+	if abs, err := filepath.Abs(filename); err == nil {
+		filename = abs
+	}
+	for _, pat := range c.Sensitive {
+		name := filename
+		if !filepath.IsAbs(pat) {
+			name = filepath.Base(filename)
+		}
+		// The pattern was checked when it was parsed.
+		if ok, _ := filepath.Match(pat, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// contextFiles returns the files that the context rules in c
+// say should be attached when editing filename.
+func (c *Config) contextFiles(filename string) ([]string, error) {
+	var files []string
+	for _, r := range c.Context {
+		name := filename
+		if !filepath.IsAbs(r.Match) {
+			name = filepath.Base(filename)
+		}
+		ok, err := filepath.Match(r.Match, name)
+		if err != nil {
+			return nil, fmt.Errorf("bad context rule pattern %q: %v", r.Match, err)
+		}
+		if !ok {
+			continue
+		}
+		files, err = globFiles(files, r.Attach, filename)
+		if err != nil {
+			return nil, fmt.Errorf("context rule for %q: %v", r.Match, err)
+		}
+	}
+	return files, nil
+}
+
+// globFiles appends the names of the files matching the
+// given patterns to files, omitting exclude and any
+// files that are already present.
+// It's an error if a pattern doesn't match any files.
+func globFiles(files, pats []string, exclude string) ([]string, error) {
+	for _, pat := range pats {
+		matches, err := filepath.Glob(pat)
+		if err != nil {
+			return nil, fmt.Errorf("bad pattern %q: %v", pat, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no files match %q", pat)
+		}
+		for _, m := range matches {
+			if m != exclude && !slices.Contains(files, m) {
+				files = append(files, m)
+			}
+		}
+	}
+	return files, nil
+}
+
+// joinText returns the concatenation of two
+// pieces of text, separated by a newline.
+func joinText(a, b string) string {
+	switch {
+	case a == "":
+		return b
+	case b == "":
+		return a
+	}
+	return strings.TrimSuffix(a, "\n") + "\n" + b
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// setFlags restores the values of the flags set by
+// applyConfig when the test finishes.
+func setFlags(t *testing.T) {
+	model, provider, url, budget, checks := *flagModel, *flagProvider, *flagURL, *flagBudget, flagChecks
+	t.Cleanup(func() {
+		*flagModel, *flagProvider, *flagURL, *flagBudget, flagChecks = model, provider, url, budget, checks
+	})
+}
+
+var applyConfigTests = []struct {
+	name         string
+	cfg          Config
+	preset       Preset
+	filename     string
+	wantModel    string
+	wantProvider string
+}{{
+	name:         "config model",
+	cfg:          Config{Provider: "openai", Model: "gpt-5"},
+	filename:     "x.go",
+	wantModel:    "gpt-5",
+	wantProvider: "openai",
+}, {
+	name:         "preset model",
+	cfg:          Config{Provider: "openai", Model: "gpt-5"},
+	preset:       Preset{Model: "claude-sonnet-4-5"},
+	filename:     "x.go",
+	wantModel:    "claude-sonnet-4-5",
+	wantProvider: "anthropic",
+}, {
+	name: "extension model",
+	cfg: Config{
+		Provider:   "anthropic",
+		Model:      "claude-sonnet-4-5",
+		Extensions: map[string]ExtensionConfig{".md": {Model: "gpt-5-mini"}},
+	},
+	filename:     "README.md",
+	wantModel:    "gpt-5-mini",
+	wantProvider: "openai",
+}, {
+	name: "extension for other files",
+	cfg: Config{
+		Provider:   "anthropic",
+		Model:      "claude-sonnet-4-5",
+		Extensions: map[string]ExtensionConfig{".md": {Model: "gpt-5-mini"}},
+	},
+	filename:     "x.go",
+	wantModel:    "claude-sonnet-4-5",
+	wantProvider: "anthropic",
+}, {
+	name:         "provider only",
+	cfg:          Config{Provider: "anthropic"},
+	filename:     "x.go",
+	wantProvider: "anthropic",
+}}
+
+func TestApplyConfig(t *testing.T) {
+	for _, test := range applyConfigTests {
+		t.Run(test.name, func(t *testing.T) {
+			setFlags(t)
+			*flagModel, *flagProvider, *flagURL = "", "", ""
+			applyConfig(&test.cfg, &test.preset, test.filename)
+			provider, model, err := selectModel()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *flagModel != test.wantModel {
+				t.Errorf("got model %q; want %q", *flagModel, test.wantModel)
+			}
+			if test.wantModel != "" && model != test.wantModel {
+				t.Errorf("selected model %q; want %q", model, test.wantModel)
+			}
+			if provider != test.wantProvider {
+				t.Errorf("got provider %q; want %q", provider, test.wantProvider)
+			}
+		})
+	}
+}
+
+var loadConfigTests = []struct {
+	name string
+
+	// files holds the files to create, keyed by slash-separated
+	// name relative to a temporary directory. The user's
+	// configuration directory is "home/AI".
+	files map[string]string
+
+	// filename holds the name of the edited file.
+	filename string
+
+	// want returns the expected configuration when
+	// the temporary directory is root.
+	want func(root string) *Config
+
+	// sensitive holds whether each named file is sensitive.
+	sensitive map[string]bool
+
+	// context holds the expected context files.
+	context []string
+
+	// wantErr holds text in the expected error, if any.
+	wantErr string
+}{{
+	name: "nested",
+	files: map[string]string{
+		"home/AI/config.cue": `
+			provider: "openai"
+			model: "gpt-5"
+			budget: 1000
+			system: "user"
+			extensions: ".go": {
+				system: "user go"
+				checks: ["go build ./..."]
+			}
+			sensitive: ["*.pem"]
+		`,
+		"proj/.ai/config.cue": `
+			provider: "anthropic"
+			model: "claude-sonnet-4-5"
+			system: "proj"
+			extensions: ".go": {
+				model: "gpt-5-mini"
+				checks: ["go vet ./..."]
+			}
+			context: [{match: "*.go", attach: ["README.md"]}]
+			presets: doc: {prompt: "document this", attach: ["doc/*.md"]}
+			sensitive: ["secrets/*"]
+		`,
+		// A JSON file is also accepted, and an empty
+		// list of checks replaces those further out.
+		"proj/sub/.ai/config.json": `{
+			"baseURL": "http://localhost:8080/v1/",
+			"system": "sub",
+			"extensions": {".go": {"system": "sub go", "checks": []}},
+			"context": [{"match": "pkg/*.go", "attach": ["notes.txt", "pkg/*.txt"]}],
+			"sensitive": ["pkg/keys.txt"]
+		}`,
+		"proj/README.md":        "readme\n",
+		"proj/doc/a.md":         "a\n",
+		"proj/secrets/k":        "k\n",
+		"proj/sub/notes.txt":    "notes\n",
+		"proj/sub/secrets/k":    "k\n",
+		"proj/sub/pkg/x.go":     "package pkg\n",
+		"proj/sub/pkg/keys.txt": "k\n",
+		"proj/sub/pkg/y.txt":    "y\n",
+	},
+	filename: "proj/sub/pkg/x.go",
+	want: func(root string) *Config {
+		return &Config{
+			Provider: "anthropic",
+			Model:    "claude-sonnet-4-5",
+			BaseURL:  "http://localhost:8080/v1/",
+			Budget:   1000,
+			System:   "user\nproj\nsub",
+			Extensions: map[string]ExtensionConfig{".go": {
+				Model:  "gpt-5-mini",
+				System: "user go\nsub go",
+				Checks: []string{},
+			}},
+			Context: []ContextRule{{
+				Match:  "*.go",
+				Attach: []string{filepath.Join(root, "proj/README.md")},
+			}, {
+				Match:  filepath.Join(root, "proj/sub/pkg/*.go"),
+				Attach: []string{filepath.Join(root, "proj/sub/notes.txt"), filepath.Join(root, "proj/sub/pkg/*.txt")},
+			}},
+			Presets: map[string]Preset{"doc": {
+				Prompt: "document this",
+				Attach: []string{filepath.Join(root, "proj/doc/*.md")},
+			}},
+			Sensitive: []string{
+				"*.pem",
+				filepath.Join(root, "proj/secrets/*"),
+				filepath.Join(root, "proj/sub/pkg/keys.txt"),
+			},
+		}
+	},
+	sensitive: map[string]bool{
+		"proj/sub/pkg/x.go":     false,
+		"proj/key.pem":          true,
+		"proj/sub/pkg/key.pem":  true,
+		"proj/secrets/k":        true,
+		"proj/sub/secrets/k":    false,
+		"proj/sub/pkg/keys.txt": true,
+		"proj/keys.txt":         false,
+	},
+	context: []string{
+		"proj/README.md",
+		"proj/sub/notes.txt",
+		"proj/sub/pkg/keys.txt",
+		"proj/sub/pkg/y.txt",
+	},
+}, {
+	name: "rule for other files",
+	files: map[string]string{
+		"proj/.ai/config.cue": `
+			context: [{match: "sub/*.go", attach: ["README.md"]}]
+		`,
+		"proj/README.md": "readme\n",
+		"proj/x.go":      "package x\n",
+	},
+	filename: "proj/x.go",
+	want: func(root string) *Config {
+		return &Config{
+			Context: []ContextRule{{
+				Match:  filepath.Join(root, "proj/sub/*.go"),
+				Attach: []string{filepath.Join(root, "proj/README.md")},
+			}},
+		}
+	},
+}, {
+	name: "no configuration",
+	files: map[string]string{
+		"proj/x.go": "package x\n",
+	},
+	filename: "proj/x.go",
+	want: func(root string) *Config {
+		return &Config{}
+	},
+}, {
+	name: "bad provider",
+	files: map[string]string{
+		"home/AI/config.cue": `provider: "gemini"`,
+		"proj/x.go":          "package x\n",
+	},
+	filename: "proj/x.go",
+	wantErr:  "invalid configuration in ",
+}, {
+	name: "unknown field",
+	files: map[string]string{
+		"proj/.ai/config.cue": `modle: "gpt-5"`,
+		"proj/x.go":           "package x\n",
+	},
+	filename: "proj/x.go",
+	wantErr:  "invalid configuration in ",
+}, {
+	name: "bad sensitive pattern",
+	files: map[string]string{
+		"proj/.ai/config.cue": `sensitive: ["[x"]`,
+		"proj/x.go":           "package x\n",
+	},
+	filename: "proj/x.go",
+	wantErr:  `bad sensitive file pattern "[x"`,
+}}
+
+func TestLoadConfig(t *testing.T) {
+	for _, test := range loadConfigTests {
+		t.Run(test.name, func(t *testing.T) {
+			root := t.TempDir()
+			writeFiles(t, root, test.files)
+			t.Setenv("XDG_CONFIG_HOME", filepath.Join(root, "home"))
+			cfg, _, err := loadConfig(filepath.Join(root, test.filename))
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("got error %v; want error containing %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := test.want(root); !reflect.DeepEqual(cfg, want) {
+				t.Errorf("got config\n%+v\nwant\n%+v", cfg, want)
+			}
+			for name, want := range test.sensitive {
+				if got := cfg.sensitive(filepath.Join(root, name)); got != want {
+					t.Errorf("sensitive(%q) = %v; want %v", name, got, want)
+				}
+			}
+			files, err := cfg.contextFiles(filepath.Join(root, test.filename))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var want []string
+			for _, name := range test.context {
+				want = append(want, filepath.Join(root, name))
+			}
+			if !slices.Equal(files, want) {
+				t.Errorf("got context files %q; want %q", files, want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigFiles(t *testing.T) {
+	// The files read are listed outermost first, and
+	// config.cue is preferred to config.json.
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"home/AI/config.json":  `{"model": "gpt-5"}`,
+		"proj/.ai/config.cue":  `model: "claude-sonnet-4-5"`,
+		"proj/.ai/config.json": `{"model": "gpt-5-mini"}`,
+	})
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(root, "home"))
+	cfg, files, err := loadConfig(filepath.Join(root, "proj/x.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		filepath.Join(root, "home/AI/config.json"),
+		filepath.Join(root, "proj/.ai/config.cue"),
+	}
+	if !slices.Equal(files, want) {
+		t.Errorf("got files %q; want %q", files, want)
+	}
+	if cfg.Model != "claude-sonnet-4-5" {
+		t.Errorf("got model %q; want claude-sonnet-4-5", cfg.Model)
+	}
+}
@@ -2,6 +2,101 @@
 
 package main
 
+// #Config describes a configuration file. Configuration
+// is read from $HOME/.config/AI/config.cue and from
+// .ai/config.cue in the directory of the edited file
+// and all its parents, with files nearer the edited file
+// taking precedence. JSON files named config.json
+// are also accepted.
+type Config struct {
+	// provider holds the default model provider.
+	Provider string `json:"provider,omitempty"`
+
+	// model holds the default model.
+	Model string `json:"model,omitempty"`
+
+	// baseURL holds the base URL of an OpenAI-compatible
+	// API server.
+	BaseURL string `json:"baseURL,omitempty"`
+
+	// budget holds the token budget for a request.
+	Budget int64 `json:"budget,omitempty"`
+
+	// system holds text to be added to the system prompt.
+	System string `json:"system,omitempty"`
+
+	// extensions holds settings for files with particular
+	// extensions, keyed by extension, for example ".go".
+	Extensions map[string]ExtensionConfig `json:"extensions,omitempty"`
+
+	// context holds rules for attaching files as context.
+	Context []ContextRule `json:"context,omitempty"`
+
+	// presets holds named presets, invoked with -p.
+	Presets map[string]Preset `json:"presets,omitempty"`
+
+	// sensitive holds glob patterns for files that must never
+	// be sent to the model, matched against file names as
+	// #ContextRule.match is. Editing such a file is refused,
+	// and such files are left out of any context.
+	Sensitive []string `json:"sensitive,omitempty"`
+}
+
+// #ExtensionConfig holds settings for files with
+// a particular extension. They take precedence over
+// the settings in #Config.
+type ExtensionConfig struct {
+	// model holds the model to use.
+	Model string `json:"model,omitempty"`
+
+	// system holds text to be added to the system prompt.
+	System string `json:"system,omitempty"`
+
+	// checks holds shell commands used to check edits,
+	// as if specified with -check.
+	Checks []string `json:"checks,omitempty"`
+}
+
+// #ContextRule describes files to attach when
+// editing particular files.
+type ContextRule struct {
+	// match holds a glob pattern matched against the name
+	// of the edited file. If it contains a slash, it's matched
+	// against the path relative to the directory containing
+	// the .ai directory; otherwise it's matched against
+	// the last element of the name.
+	Match string `json:"match"`
+
+	// attach holds glob patterns for the files to attach,
+	// relative to the directory containing the .ai directory.
+	Attach []string `json:"attach"`
+}
+
+// #Preset holds a named set of instructions and
+// associated settings.
+type Preset struct {
+	// prompt holds a Go text/template that produces the
+	// instructions. The template is executed with the fields
+	// Filename (the name of the edited file), Language
+	// (its programming language, if known) and Selection
+	// (the selected text).
+	Prompt string `json:"prompt"`
+
+	// model holds the model to use.
+	Model string `json:"model,omitempty"`
+
+	// attach holds glob patterns for files to attach,
+	// relative to the directory containing the .ai directory.
+	Attach []string `json:"attach,omitempty"`
+
+	// replyTypes holds the types of reply part that the
+	// model may use. If it's empty, all types are allowed.
+	ReplyTypes []ReplyType `json:"replyTypes,omitempty"`
+}
+
+// #ReplyType holds the type of a reply part.
+type ReplyType string
+
 // #Reply describes the full JSON response.
 type Reply struct {
 	// parts holds a sequence of parts of the reply.
@@ -84,4 +179,9 @@ type Part struct {
 	// content holds the actual content. If base64 is true, this
 	// will be base64-encoded.
 	Content string `json:"content"`
+
+	// mediaType holds the media type of an image or PDF document.
+	// Such content is attached separately, following the part,
+	// and content is empty.
+	MediaType string `json:"mediaType,omitempty"`
 }
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// definitionsPart returns a part holding the source of the
+// declarations of the package-level identifiers used in the
+// selection of the Go file filename, whose current contents are src
+// and whose selection is src[start:end]. Only declarations in the
+// same module as filename are included, and declarations in
+// filename itself or in any file for which omit returns true
+// are omitted, for example because the model can already
+// see them.
+// If limit is non-negative, no more than limit bytes of source
+// are included. It returns false if there are no such declarations.
+func definitionsPart(filename string, src []byte, start, end int, omit func(filename string) bool, limit int) (Part, bool, error) {
+	if filepath.Ext(filename) != ".go" || start == end {
+		return Part{}, false, nil
+	}
+	cfg := &packages.Config{
+		Mode: packages.NeedName | packages.NeedModule | packages.NeedSyntax |
+			packages.NeedTypes | packages.NeedTypesInfo,
+		Dir:     filepath.Dir(filename),
+		Tests:   strings.HasSuffix(filename, "_test.go"),
+		Overlay: map[string][]byte{filename: src},
+	}
+	pkgs, err := packages.Load(cfg, "file="+filename)
+	if err != nil {
+		return Part{}, false, fmt.Errorf("cannot load package: %v", err)
+	}
+	var pkg *packages.Package
+	var file *ast.File
+	for _, p := range pkgs {
+		for _, f := range p.Syntax {
+			if p.Fset.File(f.Pos()).Name() == filename {
+				pkg, file = p, f
+			}
+		}
+	}
+	if file == nil || pkg.Module == nil {
+		return Part{}, false, nil
+	}
+	d := &definitions{
+		fset:       pkg.Fset,
+		modulePath: pkg.Module.Path,
+		filename:   filename,
+		omit:       omit,
+		limit:      limit,
+		files:      make(map[string]*parsedFile),
+		seen:       make(map[token.Position]bool),
+	}
+	ast.Inspect(file, func(n ast.Node) bool {
+		id, ok := n.(*ast.Ident)
+		if !ok {
+			return true
+		}
+		if off := pkg.Fset.Position(id.Pos()).Offset; off < start || off >= end {
+			return true
+		}
+		if obj := pkg.TypesInfo.Uses[id]; obj != nil {
+			d.add(obj)
+		}
+		return true
+	})
+	if d.buf.Len() == 0 {
+		return Part{}, false, nil
+	}
+	return Part{
+		Instructions: "This part holds the declarations of identifiers used in the selection that are defined elsewhere in the module.",
+		Content:      d.buf.String(),
+	}, true, nil
+}
+
+// definitions accumulates the source of declarations.
+type definitions struct {
+	fset       *token.FileSet
+	modulePath string
+	filename   string
+	omit       func(filename string) bool
+	limit      int
+	buf        bytes.Buffer
+
+	// files holds the files parsed so far.
+	files map[string]*parsedFile
+
+	// seen records the declarations already considered.
+	seen map[token.Position]bool
+}
+
+type parsedFile struct {
+	data []byte
+	file *ast.File
+}
+
+// add adds the declaration of obj, if it's a package-level
+// object or method declared in the module.
+func (d *definitions) add(obj types.Object) {
+	if obj.Pkg() == nil || !inModule(obj.Pkg().Path(), d.modulePath) {
+		return
+	}
+	switch obj := obj.(type) {
+	case *types.Func:
+	case *types.TypeName, *types.Const, *types.Var:
+		if obj.Parent() != obj.Pkg().Scope() {
+			// A local, a field or a parameter.
+			return
+		}
+	default:
+		return
+	}
+	pos := d.fset.Position(obj.Pos())
+	if !pos.IsValid() || pos.Filename == d.filename || d.omit(pos.Filename) {
+		return
+	}
+	f, err := d.parse(pos.Filename)
+	if err != nil {
+		return
+	}
+	// Objects from other packages come from export data,
+	// which only records lines and columns.
+	tf := d.fset.File(f.file.Pos())
+	if pos.Line > tf.LineCount() {
+		return
+	}
+	offset := tf.Offset(tf.LineStart(pos.Line)) + pos.Column - 1
+	node, keyword, ok := enclosingDecl(f.file, d.fset, offset)
+	if !ok {
+		return
+	}
+	declPos := d.fset.Position(node.Pos())
+	if d.seen[declPos] {
+		return
+	}
+	d.seen[declPos] = true
+	src := f.data[declPos.Offset:d.fset.Position(node.End()).Offset]
+	if d.limit >= 0 && d.buf.Len()+len(src) > d.limit {
+		return
+	}
+	rel := pos.Filename
+	if r, err := filepath.Rel(filepath.Dir(d.filename), pos.Filename); err == nil {
+		rel = filepath.ToSlash(r)
+	}
+	fmt.Fprintf(&d.buf, "// %s:%d (package %s)\n%s%s\n\n", rel, declPos.Line, obj.Pkg().Path(), keyword, src)
+}
+
+// parse returns the parsed form of the named file.
+// Its positions are added to d.fset, but in a new file,
+// so they're only used to find offsets.
+func (d *definitions) parse(filename string) (*parsedFile, error) {
+	if f, ok := d.files[filename]; ok {
+		return f, nil
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	file, err := parser.ParseFile(d.fset, filename, data, parser.ParseComments|parser.SkipObjectResolution)
+	if err != nil {
+		return nil, err
+	}
+	f := &parsedFile{data, file}
+	d.files[filename] = f
+	return f, nil
+}
+
+// enclosingDecl returns the node holding the top-level
+// declaration in f that encloses the given byte offset,
+// including any doc comment. For a declaration in a group,
+// such as one of a block of constants, it returns the whole
+// group if it's small, and otherwise just the declaration,
+// along with the keyword that must precede it.
+func enclosingDecl(f *ast.File, fset *token.FileSet, offset int) (_ ast.Node, keyword string, _ bool) {
+	contains := func(n ast.Node) bool {
+		return fset.Position(n.Pos()).Offset <= offset && offset < fset.Position(n.End()).Offset
+	}
+	for _, decl := range f.Decls {
+		if !contains(decl) {
+			continue
+		}
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			if decl.Doc != nil {
+				return spanNode{decl.Doc.Pos(), decl.End()}, "", true
+			}
+			return decl, "", true
+		case *ast.GenDecl:
+			if decl.Doc != nil && len(decl.Specs) <= maxGroupSpecs {
+				return spanNode{decl.Doc.Pos(), decl.End()}, "", true
+			}
+			if len(decl.Specs) <= maxGroupSpecs {
+				return decl, "", true
+			}
+			for _, spec := range decl.Specs {
+				if contains(spec) {
+					return spec, decl.Tok.String() + " ", true
+				}
+			}
+		}
+	}
+	return nil, "", false
+}
+
+// maxGroupSpecs holds the largest number of declarations
+// in a group for the whole group to be included.
+const maxGroupSpecs = 10
+
+// spanNode implements ast.Node for an arbitrary span of source.
+type spanNode struct {
+	pos, end token.Pos
+}
+
+func (n spanNode) Pos() token.Pos { return n.pos }
+func (n spanNode) End() token.Pos { return n.end }
+
+// inModule reports whether the package with the given
+// import path is in the module with the given path.
+func inModule(pkgPath, modulePath string) bool {
+	return pkgPath == modulePath || strings.HasPrefix(pkgPath, modulePath+"/")
+}
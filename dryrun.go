@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// dryRunOutput holds what -n prints: the request that
+// would be sent, with the size of each of its pieces,
+// and the payload that the backend would send for it.
+type dryRunOutput struct {
+	Provider        string          `json:"provider"`
+	Model           string          `json:"model"`
+	EstimatedTokens int             `json:"estimatedTokens"`
+	System          dryRunText      `json:"system"`
+	Messages        []dryRunMessage `json:"messages"`
+	Payload         any             `json:"payload"`
+}
+
+type dryRunText struct {
+	Bytes  int    `json:"bytes"`
+	Tokens int    `json:"tokens"`
+	Text   string `json:"text"`
+}
+
+type dryRunMessage struct {
+	Role   string       `json:"role"`
+	Bytes  int          `json:"bytes"`
+	Tokens int          `json:"tokens"`
+	Parts  []dryRunPart `json:"parts,omitempty"`
+	Text   string       `json:"text,omitempty"`
+}
+
+type dryRunPart struct {
+	Bytes  int `json:"bytes"`
+	Tokens int `json:"tokens"`
+	Part
+}
+
+// dryRun writes to w the request req as it would be sent to
+// the given provider, after redaction by red (if it's not nil),
+// along with the estimated size of each part and the payload
+// that would be sent. Sizes are in bytes of content, and tokens
+// are estimated from the text sent for each part.
+func dryRun(w io.Writer, provider string, req *Request, red *redactor) error {
+	if red != nil {
+		req = red.redactRequest(req)
+	}
+	ntokens, err := estimateRequestTokens(req)
+	if err != nil {
+		return err
+	}
+	out := dryRunOutput{
+		Provider:        provider,
+		Model:           req.Model,
+		EstimatedTokens: ntokens,
+		System:          dryRunText{len(req.System), estimateTokens(req.System), req.System},
+	}
+	for _, m := range req.Messages {
+		dm := dryRunMessage{
+			Role: m.Role,
+			Text: m.Text,
+		}
+		if m.Role == "assistant" {
+			dm.Bytes, dm.Tokens = len(m.Text), estimateTokens(m.Text)
+		}
+		for _, p := range m.Parts {
+			text, err := p.text()
+			if err != nil {
+				return fmt.Errorf("cannot marshal part: %v", err)
+			}
+			dp := dryRunPart{
+				Bytes:  len(p.Content),
+				Tokens: estimateTokens(text),
+				Part:   p,
+			}
+			if p.MediaType != "" {
+				dp.Tokens += mediaTokens
+			}
+			dm.Bytes += dp.Bytes
+			dm.Tokens += dp.Tokens
+			dm.Parts = append(dm.Parts, dp)
+		}
+		out.Messages = append(out.Messages, dm)
+	}
+	out.Payload, err = requestPayload(provider, req)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(out)
+}
+
+// requestPayload returns the payload that the backend for the
+// given provider would send first for req, before any fallback.
+func requestPayload(provider string, req *Request) (any, error) {
+	switch provider {
+	case "openai":
+		return (&openaiBackend{}).params(req)
+	case "chat":
+		return (&chatBackend{}).params(req)
+	case "anthropic":
+		return (&anthropicBackend{}).request(req)
+	}
+	return nil, fmt.Errorf("unknown provider %q", provider)
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// maxOutlineLineSize holds the maximum length of a
+// line in the outline of a file that isn't Go.
+const maxOutlineLineSize = 100
+
+// focused reports whether only part of the body is sent
+// to the model, because b.focus is set and the body holds
+// more than the lines around the selection.
+func (b *bodyInfo) focused() bool {
+	if b.focus <= 0 {
+		return false
+	}
+	start, end := b.region()
+	return start > 0 || end < len(b.text)
+}
+
+// region returns the byte offsets of the start and end of
+// the region of the body sent when it's focused: the lines
+// holding the selection and b.focus lines either side.
+func (b *bodyInfo) region() (start, end int) {
+	start = bytes.LastIndexByte(b.head, '\n') + 1
+	for i := 0; i < b.focus && start > 0; i++ {
+		start = bytes.LastIndexByte(b.text[:start-1], '\n') + 1
+	}
+	end = len(b.head) + len(b.selection)
+	n := b.focus + 1
+	if bytes.HasSuffix(b.selection, []byte("\n")) {
+		// The last line of the selection is already complete.
+		n--
+	}
+	for i := 0; i < n && end < len(b.text); i++ {
+		if j := bytes.IndexByte(b.text[end:], '\n'); j >= 0 {
+			end += j + 1
+		} else {
+			end = len(b.text)
+		}
+	}
+	return start, end
+}
+
+// focusedPart returns the part describing the region
+// of the body around the selection.
+func (b *bodyInfo) focusedPart() Part {
+	start, end := b.region()
+	sel := len(b.head) + len(b.selection)
+	delim := []byte(b.delim)
+	content := slices.Concat(
+		b.text[start:len(b.head)],
+		delim,
+		b.selection,
+		delim,
+		b.text[sel:end],
+	)
+	first := bytes.Count(b.text[:start], []byte("\n")) + 1
+	last := first + bytes.Count(bytes.TrimSuffix(b.text[start:end], []byte("\n")), []byte("\n"))
+	return Part{
+		Instructions: fmt.Sprintf("Lines %d to %d of the file currently being edited, which is too large to send in full; the rest of the file is outlined in another part. The current selection is surrounded by the delimiter string %q. Only the selection can be changed.", first, last, delim),
+		Filename:     b.filename,
+		Content:      string(content),
+	}
+}
+
+// outlinePart returns the part holding an outline of the
+// body outside the region sent when it's focused.
+func (b *bodyInfo) outlinePart() Part {
+	start, end := b.region()
+	return Part{
+		Instructions: "This part holds an outline of the rest of the file currently being edited, with line numbers.",
+		Filename:     b.filename,
+		Content:      fileOutline(b.filename, b.text, start, end),
+	}
+}
+
+// fileOutline returns an outline of the structure of the named
+// file, whose contents are src, omitting anything entirely within
+// src[start:end]. Go files are outlined by their declarations
+// and Markdown files by their headings; other files are
+// outlined by their unindented lines.
+func fileOutline(filename string, src []byte, start, end int) string {
+	switch filepath.Ext(filename) {
+	case ".go":
+		if outline, err := goOutline(filename, src, start, end); err == nil {
+			return outline
+		}
+		// Probably an incomplete edit.
+	case ".md":
+		return markdownOutline(src, start, end)
+	}
+	return indentOutline(src, start, end)
+}
+
+// goOutline returns the top-level declarations in the
+// Go source src, without function bodies or imports, each
+// preceded by a comment holding its line number.
+func goOutline(filename string, src []byte, start, end int) (string, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, filename, src, parser.SkipObjectResolution)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	for _, decl := range f.Decls {
+		pos, declEnd := fset.Position(decl.Pos()), fset.Position(decl.End())
+		if pos.Offset >= start && declEnd.Offset <= end {
+			continue
+		}
+		if !outlineDecl(decl) {
+			continue
+		}
+		fmt.Fprintf(&buf, "// line %d\n", pos.Line)
+		if err := format.Node(&buf, fset, decl); err != nil {
+			return "", fmt.Errorf("cannot format declaration: %v", err)
+		}
+		buf.WriteString("\n\n")
+	}
+	return buf.String(), nil
+}
+
+// markdownOutline returns the headings in the Markdown
+// source src, ignoring lines inside fenced code blocks.
+func markdownOutline(src []byte, start, end int) string {
+	fence := ""
+	return lineOutline(src, start, end, func(line string) bool {
+		trimmed := strings.TrimLeft(line, " ")
+		for _, f := range []string{"```", "~~~"} {
+			if strings.HasPrefix(trimmed, f) {
+				switch fence {
+				case "":
+					fence = f
+				case f:
+					fence = ""
+				}
+			}
+		}
+		return fence == "" && strings.HasPrefix(line, "#")
+	})
+}
+
+// indentOutline returns the lines of src that aren't indented,
+// except for those holding only closing brackets.
+func indentOutline(src []byte, start, end int) string {
+	return lineOutline(src, start, end, func(line string) bool {
+		return line != "" &&
+			!strings.HasPrefix(line, " ") &&
+			!strings.HasPrefix(line, "\t") &&
+			strings.Trim(line, ")]}>;, ") != ""
+	})
+}
+
+// lineOutline returns the lines of src outside src[start:end]
+// that satisfy include, in order, each preceded by its line number.
+// Long lines are truncated.
+func lineOutline(src []byte, start, end int, include func(line string) bool) string {
+	var buf strings.Builder
+	offset, n := 0, 0
+	for line := range strings.Lines(string(src)) {
+		lineStart := offset
+		offset += len(line)
+		n++
+		line = strings.TrimRight(line, "\r\n")
+		// include is called for every line so that it
+		// can keep track of its state.
+		if !include(line) || lineStart >= start && lineStart < end {
+			continue
+		}
+		if len(line) > maxOutlineLineSize {
+			line = strings.ToValidUTF8(line[:maxOutlineLineSize], "") + "..."
+		}
+		fmt.Fprintf(&buf, "%d: %s\n", n, line)
+	}
+	return buf.String()
+}
+
+// withoutFullContent returns the reply part types in types,
+// or all reply part types if types is empty, except for
+// #FullContent, which can't be used when the model has
+// only seen part of the file.
+func withoutFullContent(types []ReplyType) []ReplyType {
+	if len(types) == 0 {
+		for _, t := range slices.Sorted(maps.Keys(replyTypes)) {
+			types = append(types, ReplyType(t))
+		}
+	}
+	return slices.DeleteFunc(slices.Clone(types), func(t ReplyType) bool {
+		return t == "entire"
+	})
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// testBody returns a body whose text is s with the
+// selection marked by « and ».
+func testBody(s string, focus int) *bodyInfo {
+	head, rest, _ := strings.Cut(s, "«")
+	selection, tail, _ := strings.Cut(rest, "»")
+	return &bodyInfo{
+		filename:  "/tmp/x.txt",
+		delim:     "@@",
+		text:      []byte(head + selection + tail),
+		head:      []byte(head),
+		selection: []byte(selection),
+		tail:      []byte(tail),
+		focus:     focus,
+	}
+}
+
+var regionTests = []struct {
+	name    string
+	body    string
+	focus   int
+	want    string
+	focused bool
+}{{
+	name:    "whole line",
+	body:    "a\nb\nc\n«d\n»e\nf\ng\n",
+	focus:   1,
+	want:    "c\nd\ne\n",
+	focused: true,
+}, {
+	name:    "line without newline",
+	body:    "a\nb\nc\n«d»\ne\nf\ng\n",
+	focus:   1,
+	want:    "c\nd\ne\n",
+	focused: true,
+}, {
+	name:    "empty at line start",
+	body:    "a\nb\nc\n«»d\ne\nf\ng\n",
+	focus:   1,
+	want:    "c\nd\ne\n",
+	focused: true,
+}, {
+	name:    "mid line",
+	body:    "a\nb\nc\nd«d»d\ne\nf\ng\n",
+	focus:   1,
+	want:    "c\nddd\ne\n",
+	focused: true,
+}, {
+	name:    "several lines",
+	body:    "a\nb\nc\n«d\ne\n»f\ng\n",
+	focus:   1,
+	want:    "c\nd\ne\nf\n",
+	focused: true,
+}, {
+	name:    "partial lines",
+	body:    "a\nb\nc«\nd\ne»\nf\ng\n",
+	focus:   1,
+	want:    "b\nc\nd\ne\nf\n",
+	focused: true,
+}, {
+	name:    "start of file",
+	body:    "«a\n»b\nc\nd\ne\nf\ng\n",
+	focus:   2,
+	want:    "a\nb\nc\n",
+	focused: true,
+}, {
+	name:    "end of file",
+	body:    "a\nb\nc\nd\ne\nf\n«g\n»",
+	focus:   2,
+	want:    "e\nf\ng\n",
+	focused: true,
+}, {
+	name:    "no final newline",
+	body:    "a\nb\nc\nd\ne\nf\n«g»",
+	focus:   1,
+	want:    "f\ng",
+	focused: true,
+}, {
+	name:  "whole file",
+	body:  "a\nb\nc\n«d\n»e\nf\ng\n",
+	focus: 3,
+	want:  "a\nb\nc\nd\ne\nf\ng\n",
+}, {
+	name:  "not focused",
+	body:  "a\nb\nc\n«d\n»e\nf\ng\n",
+	focus: 0,
+}}
+
+func TestRegion(t *testing.T) {
+	for _, test := range regionTests {
+		t.Run(test.name, func(t *testing.T) {
+			b := testBody(test.body, test.focus)
+			if test.focus == 0 {
+				// region isn't used when focus is zero.
+				if b.focused() {
+					t.Errorf("focused with focus 0")
+				}
+				return
+			}
+			start, end := b.region()
+			if got := string(b.text[start:end]); got != test.want {
+				t.Errorf("got region %q; want %q", got, test.want)
+			}
+			if got := b.focused(); got != test.focused {
+				t.Errorf("got focused %v; want %v", got, test.focused)
+			}
+		})
+	}
+}
+
+var focusedPartTests = []struct {
+	name      string
+	body      string
+	focus     int
+	wantLines string
+	want      string
+}{{
+	name:      "whole line",
+	body:      "a\nb\nc\n«d\n»e\nf\ng\n",
+	focus:     1,
+	wantLines: "Lines 3 to 5 ",
+	want:      "c\n@@d\n@@e\n",
+}, {
+	name:      "mid line",
+	body:      "a\nb\nc\nd«d»d\ne\nf\ng\n",
+	focus:     2,
+	wantLines: "Lines 2 to 6 ",
+	want:      "b\nc\nd@@d@@d\ne\nf\n",
+}, {
+	name:      "no final newline",
+	body:      "a\nb\nc\nd\ne\nf\n«g»",
+	focus:     1,
+	wantLines: "Lines 6 to 7 ",
+	want:      "f\n@@g@@",
+}}
+
+func TestFocusedPart(t *testing.T) {
+	for _, test := range focusedPartTests {
+		t.Run(test.name, func(t *testing.T) {
+			p := testBody(test.body, test.focus).focusedPart()
+			if p.Content != test.want {
+				t.Errorf("got content %q; want %q", p.Content, test.want)
+			}
+			if !strings.HasPrefix(p.Instructions, test.wantLines) {
+				t.Errorf("got instructions %q; want prefix %q", p.Instructions, test.wantLines)
+			}
+			if p.Filename != "/tmp/x.txt" {
+				t.Errorf("got filename %q", p.Filename)
+			}
+		})
+	}
+}
+
+var lineOutlineTests = []struct {
+	name       string
+	src        string
+	start, end int
+	want       string
+}{{
+	name:  "all",
+	src:   "a\n\tb\nc\n",
+	start: 0,
+	end:   0,
+	want:  "1: a\n3: c\n",
+}, {
+	name:  "region omitted",
+	src:   "a\nb\nc\nd\n",
+	start: 2,
+	end:   6,
+	want:  "1: a\n4: d\n",
+}, {
+	name:  "line starting in region",
+	src:   "a\nb\nc\nd\n",
+	start: 2,
+	end:   3,
+	want:  "1: a\n3: c\n4: d\n",
+}, {
+	name:  "no final newline",
+	src:   "a\r\nb",
+	start: 0,
+	end:   0,
+	want:  "1: a\n2: b\n",
+}, {
+	name:  "long line",
+	src:   strings.Repeat("x", maxOutlineLineSize+10) + "\n",
+	start: 0,
+	end:   0,
+	want:  "1: " + strings.Repeat("x", maxOutlineLineSize) + "...\n",
+}}
+
+func TestLineOutline(t *testing.T) {
+	for _, test := range lineOutlineTests {
+		t.Run(test.name, func(t *testing.T) {
+			got := lineOutline([]byte(test.src), test.start, test.end, func(line string) bool {
+				return line != "" && !strings.HasPrefix(line, "\t")
+			})
+			if got != test.want {
+				t.Errorf("got %q; want %q", got, test.want)
+			}
+		})
+	}
+}
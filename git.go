@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Values for the -diff flag.
+const (
+	diffAll  = "all"
+	diffFile = "file"
+)
+
+// gitOptions holds the git context to attach.
+type gitOptions struct {
+	// diff holds the working tree diff to attach:
+	// diffAll, diffFile, or empty for none.
+	diff string
+
+	// staged specifies whether to attach the staged changes.
+	staged bool
+
+	// logCount holds the number of commit messages
+	// to attach.
+	logCount int
+
+	// status specifies whether to attach the
+	// working tree status.
+	status bool
+
+	// omit reports whether the changes to the named
+	// file should be left out of diffs and the status.
+	omit func(filename string) bool
+}
+
+// gitParts returns parts holding the git context for the
+// given file as specified by opts. Empty diffs are omitted.
+func gitParts(filename string, opts gitOptions) ([]Part, error) {
+	dir, base := filepath.Split(filename)
+	var parts []Part
+	add := func(instructions string, args ...string) error {
+		out, err := runGit(dir, args...)
+		if err != nil {
+			return err
+		}
+		switch args[0] {
+		case "diff":
+			out, err = filterDiff(dir, out, opts.omit)
+			if err != nil {
+				return err
+			}
+		case "status":
+			out = filterStatus(dir, out, opts.omit)
+		}
+		if len(out) > 0 {
+			parts = append(parts, Part{
+				Instructions: instructions,
+				Content:      string(out),
+			})
+		}
+		return nil
+	}
+	switch opts.diff {
+	case diffAll:
+		if err := add("This part holds the unstaged changes in the git working tree, as shown by git diff.", "diff"); err != nil {
+			return nil, err
+		}
+	case diffFile:
+		if err := add("This part holds the unstaged changes to the file being edited, as shown by git diff.", "diff", "--", base); err != nil {
+			return nil, err
+		}
+	}
+	if opts.staged {
+		if err := add("This part holds the changes staged for the next commit, as shown by git diff --cached.", "diff", "--cached"); err != nil {
+			return nil, err
+		}
+	}
+	if opts.status {
+		if err := add("This part holds the status of the git working tree, relative to the directory of the file being edited, as shown by git status --short.", "status", "--short"); err != nil {
+			return nil, err
+		}
+	}
+	if opts.logCount > 0 {
+		if err := add("This part holds the most recent git commit messages for the file being edited, newest first.", "log", "-n", strconv.Itoa(opts.logCount), "--format=commit %h%nAuthor: %an%nDate: %ad%n%n%B", "--", base); err != nil {
+			return nil, err
+		}
+	}
+	return parts, nil
+}
+
+// filterDiff returns the git diff output in diff, produced
+// in dir, without the changes to files for which omit
+// returns true.
+func filterDiff(dir string, diff []byte, omit func(filename string) bool) ([]byte, error) {
+	if len(diff) == 0 {
+		return diff, nil
+	}
+	root, err := runGit(dir, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, err
+	}
+	top := string(bytes.TrimSpace(root))
+	var out []byte
+	keep := true
+	for line := range bytes.Lines(diff) {
+		if rest, ok := bytes.CutPrefix(line, []byte("diff --git a/")); ok {
+			name, _, _ := bytes.Cut(rest, []byte(" b/"))
+			keep = !omit(filepath.Join(top, filepath.FromSlash(string(name))))
+		}
+		if keep {
+			out = append(out, line...)
+		}
+	}
+	return out, nil
+}
+
+// filterStatus returns the git status --short output in
+// status, produced in dir, without the lines for files
+// for which omit returns true.
+func filterStatus(dir string, status []byte, omit func(filename string) bool) []byte {
+	var out []byte
+	for line := range bytes.Lines(status) {
+		if len(line) < 4 {
+			continue
+		}
+		// A renamed file is shown as "old -> new".
+		keep := true
+		for name := range strings.SplitSeq(strings.TrimSpace(string(line[3:])), " -> ") {
+			if unquoted, err := strconv.Unquote(name); err == nil {
+				name = unquoted
+			}
+			if omit(filepath.Join(dir, filepath.FromSlash(name))) {
+				keep = false
+			}
+		}
+		if keep {
+			out = append(out, line...)
+		}
+	}
+	return out
+}
+
+// runGit runs git in dir with the given arguments
+// and returns its output.
+func runGit(dir string, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git %s: %v: %s", args[0], err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return out, nil
+}
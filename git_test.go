@@ -0,0 +1,59 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFilterStatus(t *testing.T) {
+	status := " M main.go\n" +
+		"?? .env\n" +
+		"A  secrets/key.pem\n" +
+		"R  old.go -> .env.local\n" +
+		"R  a.go -> b.go\n" +
+		"?? \"with space.go\"\n" +
+		"?? \".env \\\"quoted\\\"\"\n"
+	omit := func(filename string) bool {
+		base := filepath.Base(filename)
+		return strings.HasPrefix(base, ".env") || filepath.Base(filepath.Dir(filename)) == "secrets"
+	}
+	want := " M main.go\n" +
+		"R  a.go -> b.go\n" +
+		"?? \"with space.go\"\n"
+	if got := string(filterStatus("/src/", []byte(status), omit)); got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
+
+func TestGitPartsStatus(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	dir := t.TempDir()
+	if _, err := runGit(dir, "init", "-q"); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.go", "b.go", ".env"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("x\n"), 0o666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	parts, err := gitParts(filepath.Join(dir, "a.go"), gitOptions{
+		status: true,
+		omit: func(filename string) bool {
+			return filename == filepath.Join(dir, ".env")
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parts) != 1 {
+		t.Fatalf("got %d parts; want 1", len(parts))
+	}
+	if want := "?? a.go\n?? b.go\n"; parts[0].Content != want {
+		t.Errorf("got status %q; want %q", parts[0].Content, want)
+	}
+}
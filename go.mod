@@ -1,17 +1,32 @@
 module github.com/acme-ai/AI
 
-go 1.24
+go 1.25.0
 
 require (
 	9fans.net/go v0.0.4
+	cuelang.org/go v0.16.1
 	github.com/go-json-experiment/json v0.0.0-20250223041408-d3c622f1b874
 	github.com/openai/openai-go v0.1.0-beta.3
-	github.com/sashabaranov/go-openai v1.38.1
+	golang.org/x/term v0.41.0
+	golang.org/x/tools v0.43.0
 )
 
 require (
+	github.com/cockroachdb/apd/v3 v3.2.1 // indirect
+	github.com/emicklei/proto v1.14.3 // indirect
+	github.com/google/uuid v1.6.0 // indirect
+	github.com/mitchellh/go-wordwrap v1.0.1 // indirect
+	github.com/pelletier/go-toml/v2 v2.2.4 // indirect
+	github.com/protocolbuffers/txtpbfmt v0.0.0-20260217160748-a481f6a22f94 // indirect
 	github.com/tidwall/gjson v1.14.4 // indirect
 	github.com/tidwall/match v1.1.1 // indirect
 	github.com/tidwall/pretty v1.2.1 // indirect
 	github.com/tidwall/sjson v1.2.5 // indirect
+	go.yaml.in/yaml/v3 v3.0.4 // indirect
+	golang.org/x/mod v0.34.0 // indirect
+	golang.org/x/net v0.52.0 // indirect
+	golang.org/x/sync v0.20.0 // indirect
+	golang.org/x/sys v0.42.0 // indirect
+	golang.org/x/text v0.35.0 // indirect
+	google.golang.org/protobuf v1.33.0 // indirect
 )
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Package context modes, as specified by the -pkg flag.
+const (
+	pkgFull    = "full"
+	pkgOutline = "outline"
+	pkgNone    = "none"
+	pkgAuto    = "auto"
+)
+
+var pkgModes = []string{pkgAuto, pkgFull, pkgOutline, pkgNone}
+
+// maxFullPackageSize holds the maximum total size of the
+// other files in a package for them to be attached in full
+// in auto mode.
+const maxFullPackageSize = 32 * 1024
+
+// maxOutlineValueSize holds the maximum size of the value
+// of a constant or variable in a package outline.
+const maxOutlineValueSize = 100
+
+// goPackageParts returns parts describing the other files in
+// the Go package containing filename, whose current contents
+// are src, according to mode. In auto mode, the files are
+// included in full if their total size is no more than
+// maxFullPackageSize and limit (unless limit is negative),
+// and outlined otherwise. Files for which omit returns
+// true are left out.
+func goPackageParts(filename string, src []byte, mode string, limit int, omit func(filename string) bool) ([]Part, error) {
+	if mode == pkgNone || filepath.Ext(filename) != ".go" {
+		return nil, nil
+	}
+	files, err := packageFiles(filename, src)
+	if err != nil {
+		return nil, err
+	}
+	files = slices.DeleteFunc(files, func(f attachment) bool {
+		return omit(f.filename)
+	})
+	if len(files) == 0 {
+		return nil, nil
+	}
+	if mode == pkgAuto {
+		size := 0
+		for _, f := range files {
+			size += len(f.data)
+		}
+		mode = pkgOutline
+		if size <= maxFullPackageSize && (limit < 0 || size <= limit) {
+			mode = pkgFull
+		}
+	}
+	if mode == pkgFull {
+		var parts []Part
+		for _, f := range files {
+			parts = append(parts, dataPart(f.filename, f.data, "this is another file in the same Go package as the file being edited"))
+		}
+		return parts, nil
+	}
+	outline, err := packageOutline(files)
+	if err != nil {
+		return nil, err
+	}
+	return []Part{{
+		Instructions: "This is an outline of the other files in the same Go package as the file being edited, holding their declarations without function bodies.",
+		Filename:     filepath.Dir(filename),
+		Content:      outline,
+	}}, nil
+}
+
+// packageFiles returns the other files in the same package
+// as filename, whose current contents are src.
+// Test files are only included when filename is a test file,
+// and files excluded by build constraints are omitted.
+func packageFiles(filename string, src []byte) ([]attachment, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, filename, src, parser.PackageClauseOnly)
+	if err != nil {
+		// Probably an incomplete edit; the package can't be known.
+		return nil, nil
+	}
+	pkgName := f.Name.Name
+	isTest := strings.HasSuffix(filename, "_test.go")
+	dir := filepath.Dir(filename)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read package directory: %v", err)
+	}
+	var files []attachment
+	for _, e := range entries {
+		name := e.Name()
+		path := filepath.Join(dir, name)
+		if path == filename || !e.Type().IsRegular() || !strings.HasSuffix(name, ".go") {
+			continue
+		}
+		if strings.HasSuffix(name, "_test.go") && !isTest {
+			continue
+		}
+		if ok, err := build.Default.MatchFile(dir, name); err != nil || !ok {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		f, err := parser.ParseFile(fset, path, data, parser.PackageClauseOnly)
+		if err != nil || f.Name.Name != pkgName {
+			continue
+		}
+		files = append(files, attachment{path, data})
+	}
+	return files, nil
+}
+
+// packageOutline returns the top-level declarations in the given
+// Go files, without function bodies, comments or imports.
+// Long constant and variable values are also omitted.
+func packageOutline(files []attachment) (string, error) {
+	fset := token.NewFileSet()
+	var buf bytes.Buffer
+	for _, file := range files {
+		f, err := parser.ParseFile(fset, file.filename, file.data, parser.SkipObjectResolution)
+		if err != nil {
+			fmt.Fprintf(&buf, "// %s: %v\n\n", filepath.Base(file.filename), err)
+			continue
+		}
+		fmt.Fprintf(&buf, "// %s\n\n", filepath.Base(file.filename))
+		for _, decl := range f.Decls {
+			if !outlineDecl(decl) {
+				continue
+			}
+			if err := format.Node(&buf, fset, decl); err != nil {
+				return "", fmt.Errorf("cannot format declaration: %v", err)
+			}
+			buf.WriteString("\n\n")
+		}
+	}
+	return buf.String(), nil
+}
+
+// outlineDecl prepares decl to be shown in an outline by
+// removing any function body and long constant and variable
+// values. It reports whether decl should be shown at all,
+// which it shouldn't be if it's an import declaration.
+func outlineDecl(decl ast.Decl) bool {
+	switch decl := decl.(type) {
+	case *ast.FuncDecl:
+		decl.Body = nil
+	case *ast.GenDecl:
+		if decl.Tok == token.IMPORT {
+			return false
+		}
+		for _, spec := range decl.Specs {
+			spec, ok := spec.(*ast.ValueSpec)
+			if !ok {
+				continue
+			}
+			for i, v := range spec.Values {
+				if int(v.End()-v.Pos()) > maxOutlineValueSize {
+					spec.Values[i] = ast.NewIdent("...")
+				}
+			}
+		}
+	}
+	return true
+}
@@ -8,16 +8,17 @@ import (
 	"encoding/base64"
 	"flag"
 	"fmt"
+	"io"
 	"iter"
+	"net/http"
 	"os"
+	"path/filepath"
 	"slices"
+	"strconv"
 	"strings"
 	"unicode/utf8"
 
 	"9fans.net/go/acme"
-	"github.com/openai/openai-go"
-	"github.com/openai/openai-go/responses"
-	"github.com/openai/openai-go/shared"
 )
 
 //go:generate cue exp gengotypes
@@ -49,23 +50,189 @@ Each part is in JSON format described by the CUE #Part schema.
 `
 
 var (
-	flagBig     = flag.Bool("big", false, "allow large files")
-	flagModel   = flag.String("m", string(openai.ChatModelGPT4o), "OpenAI model to use")
-	flagVerbose = flag.Bool("v", false, "enable verbose output")
+	flagBig        = flag.Bool("big", false, "allow requests larger than the token budget")
+	flagBudget     = flag.Int("budget", 50000, "token budget for a request")
+	flagModel      = flag.String("m", "", "model to use (default depends on provider)")
+	flagProvider   = flag.String("provider", "", "model provider to use (openai, chat or anthropic; default inferred from model name)")
+	flagURL        = flag.String("url", os.Getenv("OPENAI_BASE_URL"), "base URL of OpenAI-compatible API server")
+	flagVerbose    = flag.Bool("v", false, "enable verbose output")
+	flagTools      = flag.Bool("tools", true, "allow the model to read files in the directory of the current file")
+	flagStrict     = flag.Bool("strict", true, "require the reply to conform to the #Reply JSON Schema when the model supports it")
+	flagNew        = flag.Bool("new", false, "start a new session rather than continuing the conversation in this window")
+	flagTranscript = flag.Bool("transcript", false, "print the transcript of the current session and exit")
+	flagRounds     = flag.Int("rounds", 3, "maximum number of attempts at an edit that passes the -check commands")
+	flagRecord     = flag.String("record", "", "record the model's reply stream to the named cassette file")
+	flagReplay     = flag.String("replay", "", "replay the model's reply stream from the named cassette file instead of calling the model")
+	flagEffort     = flag.String("effort", "", "reasoning effort for reasoning models (low, medium or high)")
+	flagPreset     = flag.String("p", "", "use the named preset from the configuration")
+	flagPkg        = flag.String("pkg", pkgAuto, "context from the rest of the Go package: full, outline, none or auto")
+	flagDefs       = flag.Bool("defs", true, "attach the declarations of identifiers used in the selection of a Go file")
+	flagDiff       = flag.String("diff", "", "attach the unstaged git changes: all, or file for just the current file")
+	flagStaged     = flag.Bool("staged", false, "attach the git changes staged for the next commit")
+	flagLog        = flag.Int("log", 0, "attach the last `n` git commit messages for the current file")
+	flagStatus     = flag.Bool("status", false, "attach the git status of the working tree")
+	flagWin        = flag.String("win", "", "attach other acme windows: all, sel (those with a selection) or those matching a glob pattern")
+	flagRedact     = flag.Bool("redact", true, "replace secrets such as API keys and private keys with placeholders before sending anything")
+	flagDryRun     = flag.Bool("n", false, "print the request that would be sent, with the size of each part, and exit without sending it")
+	flagFocus      = flag.Int("focus", 0, "send only `n` lines either side of the selection and an outline of the rest of the current file")
 )
 
+var (
+	// flagChecks holds the commands specified with -check.
+	flagChecks stringsFlag
+
+	// flagCmds holds the commands specified with -cmd.
+	flagCmds stringsFlag
+)
+
+func init() {
+	flag.Var(&flagChecks, "check", "shell command used to check the edit before applying it (may be repeated)")
+	flag.Var(&flagCmds, "cmd", "shell command to run in the directory of the current file, attaching its output (may be repeated)")
+}
+
 func main1() error {
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, `
 usage: AI [<prompt> [file...]]
 
-This executes OpenAI with the given instructions on the selection
+This executes an AI model with the given instructions on the selection
 in the current file.
-Any files provided will be attached as context.
+Any files provided will be attached as context; images and
+PDF documents are sent to the model as such. A directory
+attaches the files inside it, and a directory followed by /...
+(for example ./internal/...) attaches all the files beneath it;
+glob patterns such as '*.go' are also allowed. Files found this
+way are skipped if they're ignored by git, vendored or binary,
+and dropped if they'd exceed the token budget; the files
+dropped are listed (and the files attached too, with -v).
+
+Requests whose estimated size exceeds the token budget
+(see -budget) are refused unless -big is specified.
+Files read by the model also count against the budget.
+The oldest turns of the conversation are dropped so that
+it takes no more than half the budget.
+
+To work on a file too large to send in full, use -focus to
+send only the given number of lines either side of the
+selection, along with an outline of the rest of the file:
+its declarations for Go, its headings for Markdown, and its
+unindented lines otherwise. The model may then only change
+the selection, not rewrite the whole file.
+
+The -diff, -staged, -status and -log flags attach git context:
+the unstaged changes in the working tree (-diff=all) or to the
+current file (-diff=file), the staged changes, the status of
+the working tree (as shown by git status --short), and the
+last few commit messages for the current file.
+
+The -cmd flag runs a shell command in the directory of the
+current file and attaches its output and exit status, for
+example:
+
+	AI -cmd 'go test ./...' 'fix the failing test'
+
+It may be repeated to run several commands.
+
+The -win flag attaches the bodies of other acme windows:
+-win=all attaches all of them, -win=sel attaches those with
+a non-empty selection, and any other value is a glob pattern
+matched against window names, for example -win='*.go'.
+Selections are marked as in the current window.
+
+When the current file is Go, the other files in its package
+are attached too: in full with -pkg=full, or as an outline
+of their declarations with -pkg=outline. The default,
+-pkg=auto, attaches small packages in full and outlines
+larger ones; -pkg=none attaches nothing. The source of
+the declarations used in the selection is also attached
+when they're elsewhere in the same module (see -defs).
+
+The model provider is OpenAI by default, using $OPENAI_API_KEY;
+models with names starting "claude" use Anthropic,
+using $ANTHROPIC_API_KEY. When -url is specified, the
+chat completions API is used by default, which is
+supported by local servers such as llama.cpp and Ollama.
+
+Each window holds a session, so subsequent invocations of AI
+in the same window continue the same conversation. Sessions
+expire after a day of disuse; use -new to start afresh.
+
+When -check is specified, edits are first made to a scratch
+copy of the Go module containing the file (or of the files
+in the file's directory if there is none), and each check
+command is run in the root of the copy, for example:
+
+	AI -check 'go build ./...' -check 'go vet ./...' 'rename foo to bar'
+
+If any check fails, the model is asked to fix the problem, up to
+the number of attempts specified by -rounds, and the window is
+only changed when all the checks pass.
+
+With reasoning models, any summary of the model's reasoning is
+printed as it arrives. The -effort flag sets the reasoning effort;
+with Anthropic models, it enables extended thinking.
+
+Defaults for the provider, model, API server URL and budget,
+extra system prompt text, settings for particular file extensions
+(including -check commands) and rules for attaching files
+can be set in configuration files, described by #Config in
+config.cue. These are read from $HOME/.config/AI/config.cue
+and from .ai/config.cue in the directory of the current file
+and each of its parents, with nearer files taking precedence.
+Command line flags take precedence over configuration files.
+
+The built-in system prompt can be extended by a system.md file
+and replaced by a system-override.md file in any of the directories
+holding configuration files; the outermost files are used first.
+This is a good place for a project's coding conventions.
+The -v flag shows which files were used.
+
+Configuration files can also define named presets, selected
+with -p. A preset's prompt is a Go template that can refer to
+{{.Filename}}, {{.Language}} and {{.Selection}}; any
+instructions given on the command line are sent as well.
+A preset can also specify the model, files to attach and
+the types of reply part that the model may use. For example:
+
+	presets: doc: {
+		prompt:     "Add {{.Language}} doc comments to the selection in our house style."
+		replyTypes: ["selectionReplace", "commentary"]
+	}
+
+Before anything is sent, secrets such as API keys, tokens,
+private keys, passwords and other random-looking strings
+are replaced by placeholders, which are turned back into
+the secrets if they appear in the model's edits. Use
+-redact=false to turn this off. Files matching any of the
+sensitive patterns in the configuration are never sent:
+editing one is refused, and they're left out of any context.
+
+The -n flag prints the request that would be sent as JSON,
+with the size in bytes and estimated tokens of each part,
+followed by the payload that would be sent to the provider,
+and exits without calling the model or changing the window.
+No API key is needed.
+
+If the model asks for further instruction, the question is shown
+in a new +AI window (or on the terminal, if there is one);
+type the answer below the marker line and execute Send to continue.
 `)
+		flag.PrintDefaults()
 		os.Exit(2)
 	}
 	flag.Parse()
+	if *flagEffort != "" && !slices.Contains(efforts, *flagEffort) {
+		return fmt.Errorf("invalid -effort %q; must be one of %s", *flagEffort, strings.Join(efforts, ", "))
+	}
+	if *flagDiff != "" && *flagDiff != diffAll && *flagDiff != diffFile {
+		return fmt.Errorf("invalid -diff %q; must be %s or %s", *flagDiff, diffAll, diffFile)
+	}
+	if *flagFocus < 0 {
+		return fmt.Errorf("invalid -focus %d; must not be negative", *flagFocus)
+	}
+	if !slices.Contains(pkgModes, *flagPkg) {
+		return fmt.Errorf("invalid -pkg %q; must be one of %s", *flagPkg, strings.Join(pkgModes, ", "))
+	}
 
 	win, err := acmeCurrentWin()
 	if err != nil {
@@ -73,138 +240,579 @@ Any files provided will be attached as context.
 	}
 	defer win.CloseFiles()
 
-	key := os.Getenv("OPENAI_API_KEY")
-	if key == "" {
-		return fmt.Errorf("no value set for $OPENAI_API_KEY")
+	file, body, err := currentFileParts(win, *flagFocus)
+	if err != nil {
+		return err
+	}
+	sess, err := loadSession(os.Getenv("winid"), body.filename)
+	if err != nil {
+		return err
+	}
+	if *flagTranscript {
+		return sess.writeTranscript(os.Stdout)
+	}
+	if *flagNew {
+		sess.Messages = nil
+	}
+	cfg, cfgFiles, err := loadConfig(body.filename)
+	if err != nil {
+		return err
+	}
+	if *flagVerbose && len(cfgFiles) > 0 {
+		fmt.Printf("configuration: %s\n", strings.Join(cfgFiles, ", "))
+	}
+	system, systemFiles, err := systemPromptFor(body.filename)
+	if err != nil {
+		return err
+	}
+	if *flagVerbose && len(systemFiles) > 0 {
+		fmt.Printf("system prompt: %s\n", strings.Join(systemFiles, ", "))
+	}
+	preset := new(Preset)
+	if *flagPreset != "" {
+		preset, err = cfg.preset(*flagPreset)
+		if err != nil {
+			return err
+		}
+	}
+	applyConfig(cfg, preset, body.filename)
+	if cfg.sensitive(body.filename) {
+		return fmt.Errorf("refusing to send %s: it matches a sensitive file pattern in the configuration", body.filename)
+	}
+	if body.focused() {
+		// The model can't rewrite text that it hasn't seen.
+		preset.ReplyTypes = withoutFullContent(preset.ReplyTypes)
 	}
 
-	var parts []Part
-	parts = append(parts, Part{
-		Instructions: "This holds the CUE schema for the JSON reply for you to send me",
-		Content:      schemaCUE,
-	})
+	provider, model, err := selectModel()
+	if err != nil {
+		return err
+	}
+	var red *redactor
+	if *flagRedact {
+		red = newRedactor(sess.redactKey())
+		red.keep(body.delim)
+	}
+
+	history := sess.history()
 
-	part, body, err := currentFilePart(win)
+	// The parts are ordered so that those least likely to change
+	// from one request to the next come first, which lets
+	// providers cache them: static context, then context
+	// that depends on the current state, then the file
+	// being edited and finally the instructions.
+	var static, current, instructions []Part
+	contextFiles, err := cfg.contextFiles(body.filename)
 	if err != nil {
 		return err
 	}
-	parts = append(parts, part)
+	for _, filename := range contextFiles {
+		part, err := filePart(filename, "this is a file attached by the project configuration")
+		if err != nil {
+			return err
+		}
+		static = append(static, part)
+	}
+	presetFiles, err := globFiles(contextFiles, preset.Attach, body.filename)
+	if err != nil {
+		return fmt.Errorf("preset %q: %v", *flagPreset, err)
+	}
+	for _, filename := range presetFiles[len(contextFiles):] {
+		part, err := filePart(filename, "this is a file attached by the preset")
+		if err != nil {
+			return err
+		}
+		static = append(static, part)
+	}
 
+	if *flagPreset != "" {
+		text, err := preset.instructions(presetData{
+			Filename:  body.filename,
+			Language:  fileLanguage(body.filename),
+			Selection: string(body.selection),
+		})
+		if err != nil {
+			return fmt.Errorf("preset %q: %v", *flagPreset, err)
+		}
+		instructions = append(instructions, Part{
+			Instructions: "This part holds the user instructions.",
+			Content:      text,
+		})
+	}
+	if len(preset.ReplyTypes) > 0 {
+		var types []string
+		for _, t := range preset.ReplyTypes {
+			types = append(types, string(t))
+		}
+		instructions = append(instructions, Part{
+			Instructions: "This part holds the only types of reply part that you may use.",
+			Content:      strings.Join(types, ", "),
+		})
+	}
 	args := flag.Args()
 	if len(args) > 0 {
-		parts = append(parts, Part{
+		instructions = append(instructions, Part{
 			Instructions: "This part holds the user instructions.",
 			Content:      args[0],
 		})
 		args = args[1:]
 	}
-	for _, filename := range args {
-		data, err := os.ReadFile(filename)
+
+	// withSchema returns static with the reply schema
+	// first if it isn't already in the given history.
+	withSchema := func(history []Message, static []Part) []Part {
+		if slices.ContainsFunc(history, hasSchemaPart) {
+			return static
+		}
+		return slices.Concat([]Part{{
+			Instructions: "This holds the CUE schema for the JSON reply for you to send me",
+			Content:      schemaCUE,
+		}}, static)
+	}
+	// estimate returns the estimated size of the request
+	// with the given history and no generated context.
+	estimate := func(history []Message) (int, error) {
+		return estimateRequestTokens(&Request{
+			System: system,
+			Messages: append(slices.Clip(history), Message{
+				Role:  "user",
+				Parts: slices.Concat(withSchema(history, static), file, instructions),
+			}),
+		})
+	}
+
+	// Files found in directories and by patterns, and
+	// automatically generated context, are limited
+	// to what's left of the budget, after dropping the
+	// oldest turns of the conversation so that it takes
+	// no more than half the budget.
+	limit := -1
+	if !*flagBig {
+		n := len(history)
+		history, err = trimHistory(history, func(history []Message) (bool, error) {
+			used, err := estimate(history)
+			if err != nil {
+				return false, err
+			}
+			historyTokens, err := estimateRequestTokens(&Request{Messages: history})
+			if err != nil {
+				return false, err
+			}
+			return used <= *flagBudget && historyTokens <= *flagBudget/2, nil
+		})
 		if err != nil {
 			return err
 		}
-		if len(data) > 100*1024 && !*flagBig {
-			return fmt.Errorf("refusing to send large file (%d bytes); use -big to override", len(data))
+		if len(history) < n {
+			fmt.Printf("dropped %d earlier messages to fit the token budget\n", n-len(history))
 		}
-		b64 := false
-		if !utf8.Valid(data) {
-			b64 = true
-			data = base64.StdEncoding.AppendEncode(nil, data)
+		used, err := estimate(history)
+		if err != nil {
+			return err
 		}
-		parts = append(parts, Part{
-			Instructions: "this is a file attached by the user",
-			Filename:     filename,
-			Base64:       b64,
-			Content:      string(data),
-		})
+		limit = max(*flagBudget-used, 0) * bytesPerToken
+	}
+	static = withSchema(history, static)
+	addParts := func(dst *[]Part, ps ...Part) {
+		for _, p := range ps {
+			*dst = append(*dst, p)
+			if limit >= 0 {
+				limit = max(limit-len(p.Content), 0)
+			}
+		}
+	}
+	attachments, dropped, err := collectAttachments(args, body.filename, limit)
+	if err != nil {
+		return err
+	}
+	if len(attachments) > 0 && (*flagVerbose || len(dropped) > 0) {
+		for _, a := range attachments {
+			fmt.Printf("attached %s (%d bytes)\n", a.filename, len(a.data))
+		}
+	}
+	for _, d := range dropped {
+		fmt.Printf("dropped %s: %s\n", d.filename, d.reason)
+	}
+	for _, a := range attachments {
+		addParts(&static, dataPart(a.filename, a.data, "this is a file attached by the user"))
+	}
+	pkgParts, err := goPackageParts(body.filename, body.text, *flagPkg, limit, cfg.sensitive)
+	if err != nil {
+		return err
+	}
+	addParts(&static, pkgParts...)
+	gparts, err := gitParts(body.filename, gitOptions{
+		diff:     *flagDiff,
+		staged:   *flagStaged,
+		logCount: *flagLog,
+		status:   *flagStatus,
+		omit:     cfg.sensitive,
+	})
+	if err != nil {
+		return err
+	}
+	addParts(&current, gparts...)
+	for _, command := range flagCmds {
+		p, err := commandPart(filepath.Dir(body.filename), command, limit)
+		if err != nil {
+			return err
+		}
+		addParts(&current, p)
+	}
+	if *flagWin != "" {
+		id, _ := strconv.Atoi(os.Getenv("winid"))
+		wparts, err := otherWindowParts(id, *flagWin, body.delim)
+		if err != nil {
+			return err
+		}
+		addParts(&current, wparts...)
+	}
+	// Sensitive files are never sent, however they were found.
+	sensitivePart := func(p Part) bool {
+		if p.Filename == "" || !cfg.sensitive(p.Filename) {
+			return false
+		}
+		fmt.Printf("not sending sensitive file %s\n", p.Filename)
+		return true
+	}
+	static = slices.DeleteFunc(static, sensitivePart)
+	current = slices.DeleteFunc(current, sensitivePart)
+	if *flagDefs {
+		attached := make(map[string]bool)
+		for _, p := range slices.Concat(static, current) {
+			if abs, err := filepath.Abs(p.Filename); err == nil && p.Filename != "" {
+				attached[abs] = true
+			}
+		}
+		omit := func(filename string) bool {
+			return attached[filename] || cfg.sensitive(filename)
+		}
+		start := len(body.head)
+		defs, ok, err := definitionsPart(body.filename, body.text, start, start+len(body.selection), omit, limit)
+		if err != nil {
+			// The package might be broken; carry on without.
+			if *flagVerbose {
+				fmt.Printf("cannot find definitions: %v\n", err)
+			}
+		} else if ok {
+			addParts(&current, defs)
+		}
+	}
+	parts := slices.Concat(static, current, file, instructions)
+
+	req := &Request{
+		Model:  model,
+		Effort: *flagEffort,
+		System: joinText(system, joinText(cfg.System, cfg.forFile(body.filename).System)),
+		Messages: append(history, Message{
+			Role:  "user",
+			Parts: parts,
+		}),
+	}
+	ntokens, err := estimateRequestTokens(req)
+	if err != nil {
+		return err
+	}
+	if *flagVerbose {
+		fmt.Printf("estimated request size: %d tokens\n", ntokens)
+	}
+	if ntokens > *flagBudget && !*flagBig && !*flagDryRun {
+		return fmt.Errorf("refusing to send large request (about %d tokens; budget %d); use -big to override, -focus to send less of the file or -new to start a new conversation", ntokens, *flagBudget)
+	}
+	if *flagTools {
+		// Files read by the model count against the budget too.
+		limit := (*flagBudget - ntokens) * bytesPerToken
+		if *flagBig {
+			limit = -1
+		}
+		req.Tools = fileTools(filepath.Dir(body.filename), limit, cfg.sensitive)
+	}
+	if *flagStrict {
+		req.Schema, err = replySchema(preset.ReplyTypes)
+		if err != nil {
+			return err
+		}
+	}
+	if *flagDryRun {
+		if ntokens > *flagBudget && !*flagBig {
+			fmt.Fprintf(os.Stderr, "request would be refused as too large (about %d tokens; budget %d)\n", ntokens, *flagBudget)
+		}
+		return dryRun(os.Stdout, provider, req, red)
 	}
 
-	// Build the messages
-	systemMsg := responses.EasyInputMessageParam{
-		Role: responses.EasyInputMessageRoleSystem,
-		Content: responses.EasyInputMessageContentUnionParam{
-			OfString: openai.Opt(systemPrompt),
-		},
+	backend, err := selectBackend(provider)
+	if err != nil {
+		return err
+	}
+	if *flagRecord != "" {
+		f, err := os.Create(*flagRecord)
+		if err != nil {
+			return fmt.Errorf("cannot create cassette: %v", err)
+		}
+		defer f.Close()
+		backend = newRecordingBackend(backend, f)
 	}
+	if red != nil {
+		backend = newRedactingBackend(backend, red)
+	}
+	return converse(context.Background(), backend, req, win, body, sess, preset, red)
+}
 
-	var userContent bytes.Buffer
-	for _, p := range parts {
-		aiPart, err := p.AsOpenAI()
+// converse runs the conversation started by req, applying the
+// model's edits to the window and saving the conversation
+// in the session.
+//
+// If any checks have been specified with -check, edits are
+// kept back until the edited file passes them, asking the model
+// to fix any failures, and then applied all at once.
+//
+// Any secrets redacted by red are restored in the edits.
+func converse(ctx context.Context, backend Backend, req *Request, win *acme.Win, body *bodyInfo, sess *session, preset *Preset, red *redactor) error {
+	original := ensureNewline(slices.Clip(body.text))
+	apply := func(oldText, newText []byte) error {
+		return doApply(win, oldText, newText)
+	}
+	if len(flagChecks) > 0 {
+		apply = nil
+	}
+	var usage Usage
+	if *flagVerbose {
+		defer func() {
+			printUsage(os.Stdout, req.Model, usage)
+		}()
+	}
+	failedRounds := 0
+	for {
+		sent := body.text
+		events := showReasoning(addUsage(backend.Stream(ctx, req), &usage), os.Stdout)
+		reply, question, err := streamReply(events, body, preset.allows, red, apply)
 		if err != nil {
-			return fmt.Errorf("cannot marshal part: %v", err)
-		}
-		userContent.WriteString(aiPart.Text)
-		userContent.WriteString("\n")
-	}
-
-	userMsg := responses.EasyInputMessageParam{
-		Role: responses.EasyInputMessageRoleUser,
-		Content: responses.EasyInputMessageContentUnionParam{
-			OfString: openai.Opt(userContent.String()),
-		},
-	}
-
-	// Create the client, relying on OPENAI_API_KEY in env
-	client := openai.NewClient()
-
-	ctx := context.Background()
-	respIter := streamIter(client.Responses.NewStreaming(ctx, responses.ResponseNewParams{
-		Model: responses.ChatModel(*flagModel),
-		Input: responses.ResponseNewParamsInputUnion{
-			OfInputItemList: responses.ResponseInputParam{
-				{OfMessage: &systemMsg},
-				{OfMessage: &userMsg},
-			},
-		},
-		Text: responses.ResponseTextConfigParam{
-			Format: responses.ResponseFormatTextConfigUnionParam{
-				OfJSONObject: &shared.ResponseFormatJSONObjectParam{},
-			},
-		},
-	}))
+			return err
+		}
+		req.Messages = append(req.Messages, Message{
+			Role: "assistant",
+			Text: reply,
+		})
+		if err := sess.save(req.Messages); err != nil {
+			return err
+		}
+		var followup []Part
+		switch {
+		case question != "":
+			answer, err := askUser(filepath.Dir(body.filename), question)
+			if err != nil {
+				return err
+			}
+			followup = append(followup, Part{
+				Instructions: "This part holds the user's answer to your request for further instruction.",
+				Content:      answer,
+			})
+		case apply == nil && !bytes.Equal(body.text, original):
+			result, err := runChecks(body.filename, body.text, flagChecks)
+			if err != nil {
+				return fmt.Errorf("cannot run checks: %v", err)
+			}
+			if result.failure == "" {
+				fmt.Printf("checks passed: %s\n", strings.Join(result.passed, "; "))
+				if err := doApply(win, original, body.text); err != nil {
+					return fmt.Errorf("cannot apply results to acme window: %v", err)
+				}
+				return nil
+			}
+			if len(result.passed) > 0 {
+				fmt.Printf("checks passed: %s\n", strings.Join(result.passed, "; "))
+			}
+			failedRounds++
+			if failedRounds >= *flagRounds {
+				fmt.Print(result.failure)
+				return fmt.Errorf("edit still fails checks after %d attempts; not applied", failedRounds)
+			}
+			fmt.Printf("checks failed; asking for a fix (attempt %d of %d)\n", failedRounds+1, *flagRounds)
+			followup = append(followup, Part{
+				Instructions: "Your edit has not been applied because it fails the checks shown in this part. Please fix the edited file (shown in the next part) so that the checks pass.",
+				Content:      result.failure,
+			})
+		default:
+			return nil
+		}
+		if !bytes.Equal(body.text, sent) || apply == nil {
+			followup = append(followup, body.part())
+		}
+		req.Messages = append(req.Messages, Message{
+			Role:  "user",
+			Parts: followup,
+		})
+	}
+}
 
+// streamReply applies the reply in the given events to the
+// body as it arrives, calling apply (if non-nil) with the
+// old and new text of the body after each edit.
+// The reply may only contain parts whose type satisfies allowed.
+// Any placeholders for secrets redacted by red (which may
+// be nil) are replaced by the secrets in the edits.
+// It returns the text of the reply and any question asked
+// by the model in a #FurtherInstructionNeeded part.
+func streamReply(events iter.Seq2[Event, error], body *bodyInfo, allowed func(typ string) bool, red *redactor, apply func(oldText, newText []byte) error) (reply, question string, _ error) {
 	var buf bytes.Buffer
-	for part, err := range partsIter(respIter, &buf) {
+	var questions []string
+	for part, err := range partsIter(events, &buf) {
 		if err != nil {
 			fmt.Printf("bad response:\n%s\n", buf.Bytes())
-			return fmt.Errorf("error receiving reply: %v", err)
+			return "", "", fmt.Errorf("error receiving reply: %v", err)
+		}
+		if !allowed(part.Type()) {
+			return "", "", fmt.Errorf("reply contains a %q part, which is not allowed", part.Type())
 		}
 		var newBody []byte
 		switch r := part.AsAny().(type) {
 		case *FurtherInstructionNeeded:
 			fmt.Printf("further instruction needed: %s\n", r.Message)
+			questions = append(questions, r.Message)
 			continue
 		case *FullContent:
-			newBody = body.text
+			newBody = []byte(red.restore(r.FullContent))
+			body.head, body.selection, body.tail = nil, newBody, nil
 		case *SelectionAppend:
-			body.selection = slices.Concat(body.selection, []byte(r.Text))
-			newBody = slices.Concat(body.head, body.selection, []byte(r.Text), body.tail)
+			body.selection = slices.Concat(body.selection, []byte(red.restore(r.Text)))
+			newBody = slices.Concat(body.head, body.selection, body.tail)
 		case *SelectionInsert:
-			body.selection = slices.Concat([]byte(r.Text), body.selection)
+			body.selection = slices.Concat([]byte(red.restore(r.Text)), body.selection)
 			newBody = slices.Concat(body.head, body.selection, body.tail)
 		case *SelectionReplace:
-			body.selection = []byte(r.Text)
+			body.selection = []byte(red.restore(r.Text))
 			newBody = slices.Concat(body.head, body.selection, body.tail)
 		case *Commentary:
 			fmt.Println(r.Text)
 			continue
 		default:
-			return fmt.Errorf("unhandled reply type %T", r)
+			return "", "", fmt.Errorf("unhandled reply type %T", r)
 		}
 
+		if bytes.Contains(body.selection, []byte(placeholderPrefix)) {
+			fmt.Printf("warning: edit contains a placeholder for a redacted secret that can't be restored\n")
+		}
 		body.text = ensureNewline(body.text)
 		newBody = ensureNewline(newBody)
-		if err := doApply(win, body.text, newBody); err != nil {
-			// TODO: ouch: this is racy.
-			fmt.Printf("response:\n%s\n", buf.Bytes())
-			return fmt.Errorf("cannot apply results to acme window: %v", err)
+		if apply != nil {
+			if err := apply(body.text, newBody); err != nil {
+				// TODO: ouch: this is racy.
+				fmt.Printf("response:\n%s\n", buf.Bytes())
+				return "", "", fmt.Errorf("cannot apply results to acme window: %v", err)
+			}
 		}
 		body.text = newBody
 	}
+	return buf.String(), strings.Join(questions, "\n\n"), nil
+}
+
+// showReasoning returns events, writing any reasoning
+// summary to w as it arrives.
+func showReasoning(events iter.Seq2[Event, error], w io.Writer) iter.Seq2[Event, error] {
+	return func(yield func(Event, error) bool) {
+		// midLine records whether the last reasoning
+		// written didn't end with a newline.
+		midLine := false
+		for ev, err := range events {
+			if ev.Reasoning != "" {
+				io.WriteString(w, ev.Reasoning)
+				midLine = !strings.HasSuffix(ev.Reasoning, "\n")
+			}
+			if midLine && (ev.Text != "" || ev.Done || err != nil) {
+				io.WriteString(w, "\n")
+				midLine = false
+			}
+			if !yield(ev, err) {
+				return
+			}
+		}
+	}
+}
+
+// selectModel returns the provider and model selected
+// by the command line flags.
+func selectModel() (provider, model string, err error) {
+	provider = modelProvider(*flagProvider, *flagModel, *flagURL)
+	model = *flagModel
+	if model == "" && *flagReplay == "" {
+		model = defaultModels[provider]
+		if model == "" {
+			return "", "", fmt.Errorf("no default model for provider %q; use -m", provider)
+		}
+	}
+	return provider, model, nil
+}
+
+// selectBackend returns the backend for the given provider,
+// or one that replays a cassette if -replay was specified.
+func selectBackend(provider string) (Backend, error) {
+	if *flagReplay != "" {
+		data, err := os.ReadFile(*flagReplay)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read cassette: %v", err)
+		}
+		return newReplayBackend(bytes.NewReader(data)), nil
+	}
+	return newBackend(provider, *flagURL)
+}
 
+// filePart returns a part holding the contents of the named file.
+func filePart(filename, instructions string) (Part, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return Part{}, err
+	}
+	return dataPart(filename, data, instructions), nil
+}
+
+// dataPart returns a part holding data, the contents
+// of the named file. Images and PDF documents are
+// marked with their media type so that they can be
+// sent to the model natively.
+func dataPart(filename string, data []byte, instructions string) Part {
+	if mediaType := http.DetectContentType(data); slices.Contains(mediaTypes, mediaType) {
+		return Part{
+			Instructions: instructions,
+			Filename:     filename,
+			Base64:       true,
+			Content:      base64.StdEncoding.EncodeToString(data),
+			MediaType:    mediaType,
+		}
+	}
+	b64 := false
+	if !utf8.Valid(data) {
+		b64 = true
+		data = base64.StdEncoding.AppendEncode(nil, data)
+	}
+	return Part{
+		Instructions: instructions,
+		Filename:     filename,
+		Base64:       b64,
+		Content:      string(data),
+	}
+}
+
+// stringsFlag implements flag.Value for a flag
+// that may be specified multiple times.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ", ")
+}
+
+func (f *stringsFlag) Set(s string) error {
+	*f = append(*f, s)
 	return nil
 }
 
+// hasSchemaPart reports whether m holds the part
+// describing the reply schema.
+func hasSchemaPart(m Message) bool {
+	return slices.ContainsFunc(m.Parts, func(p Part) bool {
+		return p.Content == schemaCUE
+	})
+}
+
 func ensureNewline(data []byte) []byte {
 	if !bytes.HasSuffix(data, []byte("\n")) {
 		data = append(data, '\n')
@@ -213,63 +821,108 @@ func ensureNewline(data []byte) []byte {
 }
 
 type bodyInfo struct {
+	filename  string
+	delim     string
 	text      []byte
 	head      []byte
 	selection []byte
 	tail      []byte
+
+	// focus holds the number of lines either side of the
+	// selection to send when the body is larger than that,
+	// or zero to send the whole body.
+	focus int
 }
 
-func currentFilePart(win *acme.Win) (part Part, info *bodyInfo, err error) {
+// currentFileParts returns the parts describing the file
+// being edited: its contents or, if focus is non-zero and
+// the file has more than focus lines either side of the
+// selection, just those lines and an outline of the rest.
+func currentFileParts(win *acme.Win, focus int) (parts []Part, info *bodyInfo, err error) {
+	info, err = readBody(win)
+	if err != nil {
+		return nil, nil, err
+	}
+	info.focus = focus
+	if info.focused() {
+		return []Part{info.outlinePart(), info.part()}, info, nil
+	}
+	return []Part{info.part()}, info, nil
+}
+
+// readBody returns the contents of the body of the
+// given window, split at its current selection.
+func readBody(win *acme.Win) (*bodyInfo, error) {
 	var buf bytes.Buffer
 	if err := copyBody(&buf, win); err != nil {
-		return Part{}, nil, fmt.Errorf("cannot copy window body: %v", err)
+		return nil, fmt.Errorf("cannot copy window body: %v", err)
 	}
 	body := buf.Bytes()
 
-	_, _, err = win.ReadAddr() // ensure address file is open
+	_, _, err := win.ReadAddr() // ensure address file is open
 	if err != nil {
-		return Part{}, nil, fmt.Errorf("cannot read address: %v", err)
+		return nil, fmt.Errorf("cannot read address: %v", err)
 	}
 	if err := win.Ctl("addr=dot"); err != nil {
-		return Part{}, nil, fmt.Errorf("cannot set address: %v", err)
+		return nil, fmt.Errorf("cannot set address: %v", err)
 	}
 	a0, a1, err := win.ReadAddr()
 	if err != nil {
-		return Part{}, nil, fmt.Errorf("cannot get dot: %v", err)
+		return nil, fmt.Errorf("cannot get dot: %v", err)
 	}
 	a0b, a1b := runeOffset2ByteOffset(body, a0), runeOffset2ByteOffset(body, a1)
 
-	head := body[:a0b]
-	selection := body[a0b:a1b]
-	tail := body[a1b:]
-
-	delim := []byte(uniqID())
-	hbody := slices.Concat(
-		head,
-		delim,
-		selection,
-		delim,
-		tail,
-	)
-
 	tagBytes, err := win.ReadAll("tag")
 	if err != nil {
-		return Part{}, nil, fmt.Errorf("cannot read tag: %v", err)
+		return nil, fmt.Errorf("cannot read tag: %v", err)
 	}
 	filename, _, _ := strings.Cut(string(tagBytes), " ")
 
-	part = Part{
+	return &bodyInfo{
+		filename:  filename,
+		delim:     uniqID(),
+		text:      body,
+		head:      body[:a0b],
+		selection: body[a0b:a1b],
+		tail:      body[a1b:],
+	}, nil
+}
+
+// part returns the part describing the current
+// contents of the body, or just the region around
+// the selection if it's focused.
+func (b *bodyInfo) part() Part {
+	if b.focused() {
+		return b.focusedPart()
+	}
+	delim := []byte(b.delim)
+	hbody := slices.Concat(
+		b.head,
+		delim,
+		b.selection,
+		delim,
+		b.tail,
+	)
+	return Part{
 		Instructions: fmt.Sprintf("Contents of the file currently being edited. The current selection is surrounded by the delimiter string %q", delim),
-		Filename:     filename,
+		Filename:     b.filename,
 		Content:      string(hbody),
 	}
-	info = &bodyInfo{
-		text:      body,
-		head:      head,
-		selection: selection,
-		tail:      tail,
+}
+
+// windowPart returns the part describing the contents
+// of a window other than the one being edited.
+func (b *bodyInfo) windowPart() Part {
+	if len(b.selection) == 0 {
+		return Part{
+			Instructions: "Contents of another window open in the editor.",
+			Filename:     b.filename,
+			Content:      string(b.text),
+		}
 	}
-	return part, info, nil
+	p := b.part()
+	p.Instructions = fmt.Sprintf("Contents of another window open in the editor. Its current selection is surrounded by the delimiter string %q", b.delim)
+	return p
 }
 
 func uniqID() string {
@@ -296,5 +949,8 @@ func streamIter[T any](s stream[T]) iter.Seq2[T, error] {
 				return
 			}
 		}
+		if err := s.Err(); err != nil {
+			yield(*new(T), err)
+		}
 	}
 }
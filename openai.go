@@ -0,0 +1,331 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+	"github.com/openai/openai-go/responses"
+	"github.com/openai/openai-go/shared"
+)
+
+// openaiBackend implements Backend using the OpenAI Responses API.
+type openaiBackend struct {
+	client openai.Client
+
+	// noSchema records that the model has rejected
+	// our request for structured output.
+	noSchema bool
+
+	// noSummary records that the server has rejected
+	// our request for a summary of the model's reasoning.
+	noSummary bool
+}
+
+// newOpenAIBackend returns a backend that talks to the
+// Responses endpoint at the given base URL.
+// If baseURL is empty, the default OpenAI endpoint is used.
+func newOpenAIBackend(baseURL string) (*openaiBackend, error) {
+	opts, err := openaiOptions(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	return &openaiBackend{
+		client: openai.NewClient(opts...),
+	}, nil
+}
+
+func (b *openaiBackend) Stream(ctx context.Context, req *Request) iter.Seq2[Event, error] {
+	return withFallback(func() iter.Seq2[Event, error] {
+		return b.stream(ctx, req)
+	}, func() bool {
+		// Reasoning summaries aren't available to all
+		// organizations, and the model might not support
+		// structured output, so fall back to doing without
+		// a summary and then to JSON mode.
+		switch {
+		case b.reasoning(req) && !b.noSummary:
+			b.noSummary = true
+		case req.Schema != nil && !b.noSchema:
+			b.noSchema = true
+		default:
+			return false
+		}
+		return true
+	})
+}
+
+func (b *openaiBackend) stream(ctx context.Context, req *Request) iter.Seq2[Event, error] {
+	return func(yield func(Event, error) bool) {
+		params, err := b.params(req)
+		if err != nil {
+			yield(Event{}, err)
+			return
+		}
+		var usage Usage
+		for round := 0; ; round++ {
+			var calls []responses.ResponseFunctionToolCall
+			var completed *responses.Response
+		events:
+			for ev, err := range streamIter(b.client.Responses.NewStreaming(ctx, params)) {
+				if err != nil {
+					yield(Event{}, err)
+					return
+				}
+				// The SDK doesn't know about reasoning summary events.
+				switch ev.Type {
+				case "response.reasoning_summary_text.delta":
+					if !yield(Event{Reasoning: ev.Delta}, nil) {
+						return
+					}
+					continue
+				case "response.reasoning_summary_text.done":
+					if !yield(Event{Reasoning: "\n\n"}, nil) {
+						return
+					}
+					continue
+				}
+				switch ev := ev.AsAny().(type) {
+				case responses.ResponseTextDeltaEvent:
+					if !yield(Event{Text: ev.Delta}, nil) {
+						return
+					}
+				case responses.ResponseOutputItemDoneEvent:
+					if ev.Item.Type == "function_call" {
+						calls = append(calls, ev.Item.AsFunctionCall())
+					}
+				case responses.ResponseCompletedEvent:
+					completed = &ev.Response
+					break events
+				case responses.ResponseFailedEvent:
+					yield(Event{}, fmt.Errorf("response failed: %s", ev.Response.Error.Message))
+					return
+				case responses.ResponseIncompleteEvent:
+					yield(Event{}, fmt.Errorf("response incomplete: %s", ev.Response.IncompleteDetails.Reason))
+					return
+				case responses.ResponseErrorEvent:
+					yield(Event{}, fmt.Errorf("response error: %s", ev.Message))
+					return
+				}
+			}
+			if completed == nil {
+				yield(Event{}, io.ErrUnexpectedEOF)
+				return
+			}
+			u := completed.Usage
+			usage.add(Usage{
+				InputTokens:     u.InputTokens,
+				CachedTokens:    u.InputTokensDetails.CachedTokens,
+				OutputTokens:    u.OutputTokens,
+				ReasoningTokens: u.OutputTokensDetails.ReasoningTokens,
+			})
+			if len(calls) == 0 {
+				yield(Event{
+					Done:  true,
+					Usage: &usage,
+				}, nil)
+				return
+			}
+			if round >= maxToolRounds {
+				yield(Event{}, fmt.Errorf("too many rounds of tool calls"))
+				return
+			}
+			// Continue the same response with the results of
+			// the tool calls.
+			var input responses.ResponseInputParam
+			for _, call := range calls {
+				input = append(input, responses.ResponseInputItemUnionParam{
+					OfFunctionCallOutput: &responses.ResponseInputItemFunctionCallOutputParam{
+						CallID: call.CallID,
+						Output: callTool(req.Tools, call.Name, call.Arguments),
+					},
+				})
+			}
+			params.PreviousResponseID = openai.String(completed.ID)
+			params.Input = responses.ResponseNewParamsInputUnion{
+				OfInputItemList: input,
+			}
+		}
+	}
+}
+
+func (b *openaiBackend) params(req *Request) (responses.ResponseNewParams, error) {
+	systemMsg := responses.EasyInputMessageParam{
+		Role: responses.EasyInputMessageRoleSystem,
+		Content: responses.EasyInputMessageContentUnionParam{
+			OfString: openai.Opt(req.System),
+		},
+	}
+
+	input := responses.ResponseInputParam{
+		{OfMessage: &systemMsg},
+	}
+	for _, m := range req.Messages {
+		if m.Role == "assistant" {
+			input = append(input, responses.ResponseInputItemUnionParam{
+				OfMessage: &responses.EasyInputMessageParam{
+					Role: responses.EasyInputMessageRoleAssistant,
+					Content: responses.EasyInputMessageContentUnionParam{
+						OfString: openai.Opt(m.Text),
+					},
+				},
+			})
+			continue
+		}
+		content, err := openaiContent(m)
+		if err != nil {
+			return responses.ResponseNewParams{}, err
+		}
+		input = append(input, responses.ResponseInputItemUnionParam{
+			OfMessage: &responses.EasyInputMessageParam{
+				Role: responses.EasyInputMessageRoleUser,
+				Content: responses.EasyInputMessageContentUnionParam{
+					OfInputItemContentList: content,
+				},
+			},
+		})
+	}
+	params := responses.ResponseNewParams{
+		Model: responses.ChatModel(req.Model),
+		Input: responses.ResponseNewParamsInputUnion{
+			OfInputItemList: input,
+		},
+	}
+	if b.reasoning(req) {
+		params.Reasoning = shared.ReasoningParam{
+			Effort: shared.ReasoningEffort(req.Effort),
+		}
+		if !b.noSummary {
+			params.Reasoning.WithExtraFields(map[string]any{
+				"summary": "auto",
+			})
+		}
+	}
+	for _, t := range req.Tools {
+		params.Tools = append(params.Tools, responses.ToolUnionParam{
+			OfFunction: &responses.FunctionToolParam{
+				Name:        t.Name,
+				Description: openai.String(t.Description),
+				Parameters:  t.Parameters,
+				Strict:      true,
+			},
+		})
+	}
+	if req.Schema != nil && !b.noSchema {
+		params.Text.Format.OfJSONSchema = &responses.ResponseFormatTextJSONSchemaConfigParam{
+			Name:   "reply",
+			Schema: req.Schema,
+			Strict: openai.Bool(true),
+		}
+	} else {
+		params.Text.Format.OfJSONObject = &shared.ResponseFormatJSONObjectParam{}
+	}
+	return params, nil
+}
+
+// openaiContent returns the content of the user message m,
+// with an input_text item for each part, and with
+// images and PDF documents as input_image and
+// input_file items.
+func openaiContent(m Message) (responses.ResponseInputMessageContentListParam, error) {
+	var content responses.ResponseInputMessageContentListParam
+	err := userContent(m, func(text string) {
+		content = append(content, responses.ResponseInputContentUnionParam{
+			OfInputText: &responses.ResponseInputTextParam{
+				Text: text,
+			},
+		})
+	}, func(p Part) {
+		if strings.HasPrefix(p.MediaType, "image/") {
+			content = append(content, responses.ResponseInputContentUnionParam{
+				OfInputImage: &responses.ResponseInputImageParam{
+					ImageURL: openai.String(dataURL(p)),
+					Detail:   responses.ResponseInputImageDetailAuto,
+				},
+			})
+			return
+		}
+		content = append(content, responses.ResponseInputContentUnionParam{
+			OfInputFile: &responses.ResponseInputFileParam{
+				Filename: openai.String(filepath.Base(p.Filename)),
+				FileData: openai.String(dataURL(p)),
+			},
+		})
+	})
+	return content, err
+}
+
+// reasoning reports whether req should be sent with
+// reasoning parameters. An explicit effort is taken to
+// mean that the model supports reasoning.
+func (b *openaiBackend) reasoning(req *Request) bool {
+	return req.Effort != "" || isReasoningModel(req.Model)
+}
+
+// openaiOptions returns the client options for talking to
+// an OpenAI-compatible server at the given base URL.
+// If baseURL is empty, the default OpenAI endpoint is used
+// and $OPENAI_API_KEY must be set.
+func openaiOptions(baseURL string) ([]option.RequestOption, error) {
+	if baseURL == "" {
+		if !hasOpenAIKey() {
+			return nil, fmt.Errorf("no value set for $OPENAI_API_KEY")
+		}
+		return nil, nil
+	}
+	opts := []option.RequestOption{
+		option.WithBaseURL(baseURL),
+	}
+	if !hasOpenAIKey() {
+		// Local servers don't usually need a key, but
+		// the client insists on sending one.
+		opts = append(opts, option.WithAPIKey("none"))
+	}
+	return opts, nil
+}
+
+// withFallback returns the events produced by stream.
+// If stream fails because the server rejects the request
+// before any events have been produced, fallback is called
+// to adjust the request; if it returns true, stream is
+// called again.
+func withFallback(stream func() iter.Seq2[Event, error], fallback func() bool) iter.Seq2[Event, error] {
+	return func(yield func(Event, error) bool) {
+		for retry := true; retry; {
+			retry = false
+			started := false
+			for ev, err := range stream() {
+				if err != nil && !started && isBadRequest(err) && fallback() {
+					retry = true
+					break
+				}
+				started = true
+				if !yield(ev, err) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// isBadRequest reports whether err represents a rejection
+// of the request by the server.
+func isBadRequest(err error) bool {
+	var apiErr *openai.Error
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	return apiErr.StatusCode == http.StatusBadRequest || apiErr.StatusCode == http.StatusUnprocessableEntity
+}
+
+func hasOpenAIKey() bool {
+	return os.Getenv("OPENAI_API_KEY") != ""
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/template"
+)
+
+// presetData holds the values available to
+// the prompt template of a preset.
+type presetData struct {
+	Filename  string
+	Language  string
+	Selection string
+}
+
+// languages holds the programming languages of files,
+// keyed by extension.
+var languages = map[string]string{
+	".c":     "C",
+	".cc":    "C++",
+	".cpp":   "C++",
+	".cue":   "CUE",
+	".go":    "Go",
+	".h":     "C",
+	".html":  "HTML",
+	".java":  "Java",
+	".js":    "JavaScript",
+	".json":  "JSON",
+	".md":    "Markdown",
+	".proto": "Protocol Buffers",
+	".py":    "Python",
+	".rs":    "Rust",
+	".sh":    "shell",
+	".sql":   "SQL",
+	".ts":    "TypeScript",
+	".yaml":  "YAML",
+	".yml":   "YAML",
+}
+
+// fileLanguage returns the programming language of the
+// named file, or the empty string if it isn't known.
+func fileLanguage(filename string) string {
+	return languages[filepath.Ext(filename)]
+}
+
+// preset returns the named preset.
+func (c *Config) preset(name string) (*Preset, error) {
+	p, ok := c.Presets[name]
+	if !ok {
+		names := slices.Sorted(maps.Keys(c.Presets))
+		if len(names) == 0 {
+			return nil, fmt.Errorf("unknown preset %q (no presets are configured)", name)
+		}
+		return nil, fmt.Errorf("unknown preset %q (known presets: %s)", name, strings.Join(names, ", "))
+	}
+	return &p, nil
+}
+
+// instructions returns the instructions produced by
+// executing the prompt template of p with data.
+func (p *Preset) instructions(data presetData) (string, error) {
+	tmpl, err := template.New("prompt").Parse(p.Prompt)
+	if err != nil {
+		return "", fmt.Errorf("cannot parse preset prompt: %v", err)
+	}
+	var buf strings.Builder
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("cannot execute preset prompt: %v", err)
+	}
+	return buf.String(), nil
+}
+
+// allows reports whether the reply part type typ
+// is allowed by p.
+func (p *Preset) allows(typ string) bool {
+	return len(p.ReplyTypes) == 0 || slices.Contains(p.ReplyTypes, ReplyType(typ))
+}
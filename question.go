@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+
+	"9fans.net/go/acme"
+	"golang.org/x/term"
+)
+
+// answerMarker separates the question from the user's
+// answer in the question window.
+const answerMarker = "---- answer below this line, then execute Send ----"
+
+// askUser shows the given question to the user and returns their answer.
+// If standard input is a terminal, the question is asked there;
+// otherwise a new acme window named dir/+AI is created
+// to hold the question and the answer.
+func askUser(dir, question string) (string, error) {
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		fmt.Printf("%s\n> ", question)
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil {
+			return "", fmt.Errorf("cannot read answer: %v", err)
+		}
+		return strings.TrimSpace(line), nil
+	}
+	return acmeAsk(dir, question)
+}
+
+func acmeAsk(dir, question string) (string, error) {
+	win, err := acme.New()
+	if err != nil {
+		return "", fmt.Errorf("cannot create acme window: %v", err)
+	}
+	defer win.CloseFiles()
+	if err := win.Name("%s/+AI", dir); err != nil {
+		return "", err
+	}
+	if _, err := win.Write("tag", []byte("Send ")); err != nil {
+		return "", err
+	}
+	if err := win.Fprintf("body", "%s\n\n%s\n", question, answerMarker); err != nil {
+		return "", err
+	}
+	win.Ctl("clean")
+	for {
+		e, err := win.ReadEvent()
+		if err != nil {
+			return "", fmt.Errorf("cannot read acme event: %v", err)
+		}
+		if e.C2 != 'x' && e.C2 != 'X' {
+			win.WriteEvent(e)
+			continue
+		}
+		switch string(e.Text) {
+		case "Send":
+			var buf bytes.Buffer
+			if err := copyBody(&buf, win); err != nil {
+				return "", fmt.Errorf("cannot read answer: %v", err)
+			}
+			_, answer, ok := strings.Cut(buf.String(), answerMarker)
+			answer = strings.TrimSpace(answer)
+			if !ok || answer == "" {
+				continue
+			}
+			win.Ctl("clean")
+			win.Del(true)
+			return answer, nil
+		case "Del":
+			win.Del(true)
+			return "", fmt.Errorf("question cancelled")
+		default:
+			win.WriteEvent(e)
+		}
+	}
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+	"iter"
+	"maps"
+	"math"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// placeholderPrefix starts every placeholder for a redacted secret.
+const placeholderPrefix = "[REDACTED:"
+
+// redactedPrompt is added to the system prompt
+// when any secrets have been redacted.
+const redactedPrompt = `
+Secrets such as keys and passwords have been replaced by placeholders
+starting "` + placeholderPrefix + `". Leave any such placeholder exactly as it is
+when it appears in text that you send back; the secret will be restored.
+`
+
+// minEntropyTokenSize holds the minimum length of a
+// high-entropy string for it to be considered a secret.
+const minEntropyTokenSize = 32
+
+// minTokenEntropy holds the minimum Shannon entropy, in bits
+// per character, of a string for it to be considered a secret.
+const minTokenEntropy = 4.5
+
+// secretPattern describes a kind of secret.
+type secretPattern struct {
+	kind string
+
+	// re matches the secret. If it has a subexpression,
+	// only the text matched by that is secret.
+	re *regexp.Regexp
+
+	// ok, if non-nil, reports whether a match
+	// is really a secret.
+	ok func(secret string) bool
+}
+
+// secretPatterns holds the kinds of secret that are redacted.
+var secretPatterns = []secretPattern{{
+	kind: "private-key",
+	re:   regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`),
+}, {
+	kind: "aws-key",
+	re:   regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`),
+}, {
+	kind: "github-token",
+	re:   regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})`),
+}, {
+	kind: "api-key",
+	re:   regexp.MustCompile(`\bsk-[A-Za-z0-9_-]{20,}`),
+}, {
+	kind: "slack-token",
+	re:   regexp.MustCompile(`\bxox[abprs]-[A-Za-z0-9-]{10,}`),
+}, {
+	kind: "google-api-key",
+	re:   regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}`),
+}, {
+	kind: "jwt",
+	re:   regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{10,}\.[A-Za-z0-9_-]{10,}\.[A-Za-z0-9_-]{10,}`),
+}, {
+	// A quoted value assigned to something that sounds secret.
+	kind: "secret",
+	re:   regexp.MustCompile(`(?i)(?:password|passwd|secret|token|api_?key|access_?key)[\w.-]*["']?\s*[:=]+\s*["']([^"'\s]{8,})["']`),
+}, {
+	// An unquoted value in an environment, INI or YAML file.
+	kind: "secret",
+	re:   regexp.MustCompile(`(?im)^[ \t]*(?:export[ \t]+)?[\w.-]*(?:password|passwd|secret|token|api_?key|access_?key)[\w.-]*[ \t]*[:=][ \t]*([^\s"'#()\[\]{},;]{8,})[ \t]*\r?$`),
+	ok: func(s string) bool {
+		return !qualifiedIdentPattern.MatchString(s)
+	},
+}, {
+	kind: "token",
+	re:   regexp.MustCompile(fmt.Sprintf(`(?:h1:)?[A-Za-z0-9+/_=-]{%d,}`, minEntropyTokenSize)),
+	ok: func(s string) bool {
+		// Checksums in go.sum files aren't secret,
+		// and nor are long identifiers in code.
+		return !strings.HasPrefix(s, "h1:") && !isWordyIdent(s) && isHighEntropy(s)
+	},
+}}
+
+// qualifiedIdentPattern matches expressions such as cfg.Token,
+// which are assigned to secret-sounding names in code.
+var qualifiedIdentPattern = regexp.MustCompile(`^[A-Za-z_]\w*(?:\.[A-Za-z_]\w*)+$`)
+
+// identPattern matches an identifier.
+var identPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// identWordPattern matches a word in an identifier: a
+// capitalized or lower case word, an acronym, or a number.
+var identWordPattern = regexp.MustCompile(`[A-Z]?[a-z]+|[A-Z]+|[0-9]+`)
+
+// minIdentWordSize holds the minimum average length of the
+// words in an identifier for it to be considered a name
+// rather than a random string.
+const minIdentWordSize = 3
+
+// isWordyIdent reports whether s is an identifier made up of
+// words, such as ChatModelGPT4oMiniSearchPreview2025_03_11,
+// rather than a random string that happens to be a valid
+// identifier, which splits into many short "words".
+func isWordyIdent(s string) bool {
+	if !identPattern.MatchString(s) {
+		return false
+	}
+	words := identWordPattern.FindAllString(s, -1)
+	n := 0
+	for _, w := range words {
+		n += len(w)
+	}
+	return len(words) > 0 && n >= minIdentWordSize*len(words)
+}
+
+// redactor replaces secrets with placeholders and
+// restores them again.
+//
+// Each placeholder is derived from its secret with a key,
+// so that the same secret always has the same placeholder
+// for a given key, without the placeholder revealing it.
+type redactor struct {
+	key []byte
+
+	// secrets holds the secrets redacted so far,
+	// keyed by placeholder.
+	secrets map[string]string
+
+	// delims holds the selection delimiters, which
+	// are never redacted (see keep).
+	delims []string
+}
+
+// newRedactor returns a redactor that uses the given key
+// to derive placeholders.
+func newRedactor(key []byte) *redactor {
+	return &redactor{
+		key:     key,
+		secrets: make(map[string]string),
+	}
+}
+
+// keep records that the given selection delimiter must be
+// left intact. Text either side of it is redacted separately,
+// so that it can't be taken as part of a secret when the
+// selection starts or ends with something long such as
+// an identifier.
+func (r *redactor) keep(delim string) {
+	r.delims = append(r.delims, delim)
+}
+
+// redact returns text with any secrets
+// replaced by placeholders.
+func (r *redactor) redact(text string) string {
+	for _, d := range r.delims {
+		if strings.Contains(text, d) {
+			pieces := strings.Split(text, d)
+			for i, p := range pieces {
+				pieces[i] = r.redact(p)
+			}
+			return strings.Join(pieces, d)
+		}
+	}
+	for _, p := range secretPatterns {
+		matches := p.re.FindAllStringSubmatchIndex(text, -1)
+		if len(matches) == 0 {
+			continue
+		}
+		var buf strings.Builder
+		last := 0
+		for _, m := range matches {
+			start, end := m[0], m[1]
+			if len(m) > 2 {
+				start, end = m[2], m[3]
+			}
+			secret := text[start:end]
+			if strings.Contains(secret, placeholderPrefix) || p.ok != nil && !p.ok(secret) {
+				// Already redacted, or not a secret.
+				continue
+			}
+			buf.WriteString(text[last:start])
+			buf.WriteString(r.placeholder(p.kind, secret))
+			last = end
+		}
+		buf.WriteString(text[last:])
+		text = buf.String()
+	}
+	return text
+}
+
+// placeholder returns the placeholder for the given
+// secret, recording the secret so it can be restored.
+func (r *redactor) placeholder(kind, secret string) string {
+	mac := hmac.New(sha256.New, r.key)
+	mac.Write([]byte(secret))
+	p := fmt.Sprintf("%s%s:%x]", placeholderPrefix, kind, mac.Sum(nil)[:4])
+	r.secrets[p] = secret
+	return p
+}
+
+// restore returns text with any placeholders for secrets
+// redacted by r replaced by the original secrets.
+// If r is nil, it returns text unchanged.
+func (r *redactor) restore(text string) string {
+	if r == nil || !strings.Contains(text, placeholderPrefix) {
+		return text
+	}
+	var oldnew []string
+	for p, secret := range r.secrets {
+		oldnew = append(oldnew, p, secret)
+	}
+	return strings.NewReplacer(oldnew...).Replace(text)
+}
+
+// redactPart returns p with any secrets in its
+// instructions and text content redacted.
+func (r *redactor) redactPart(p Part) Part {
+	p.Instructions = r.redact(p.Instructions)
+	if !p.Base64 && p.MediaType == "" {
+		p.Content = r.redact(p.Content)
+	}
+	return p
+}
+
+// redactRequest returns a copy of req with any secrets in its
+// system prompt and user messages redacted, and with its tools
+// changed to redact their results.
+func (r *redactor) redactRequest(req *Request) *Request {
+	req1 := *req
+	req1.System = r.redact(req.System)
+	req1.Messages = make([]Message, len(req.Messages))
+	for i, m := range req.Messages {
+		if m.Role == "user" {
+			m.Parts = slices.Clone(m.Parts)
+			for j, p := range m.Parts {
+				m.Parts[j] = r.redactPart(p)
+			}
+		}
+		req1.Messages[i] = m
+	}
+	if len(r.secrets) > 0 {
+		req1.System += redactedPrompt
+	}
+	req1.Tools = make([]*Tool, len(req.Tools))
+	for i, t := range req.Tools {
+		t1 := *t
+		t1.Call = func(args []byte) (string, error) {
+			result, err := t.Call(args)
+			return r.redact(result), err
+		}
+		req1.Tools[i] = &t1
+	}
+	return &req1
+}
+
+// kinds returns the kinds of secret redacted so far.
+func (r *redactor) kinds() []string {
+	var kinds []string
+	for p := range maps.Keys(r.secrets) {
+		kind, _, _ := strings.Cut(strings.TrimPrefix(p, placeholderPrefix), ":")
+		kinds = append(kinds, kind)
+	}
+	slices.Sort(kinds)
+	return slices.Compact(kinds)
+}
+
+// isHighEntropy reports whether s looks like a random
+// string, such as a key or token: it must contain upper
+// and lower case letters and digits, and have a high
+// Shannon entropy.
+func isHighEntropy(s string) bool {
+	if !strings.ContainsAny(s, "0123456789") ||
+		strings.ToLower(s) == s ||
+		strings.ToUpper(s) == s {
+		return false
+	}
+	counts := make(map[rune]int)
+	for _, c := range s {
+		counts[c]++
+	}
+	entropy := 0.0
+	for _, n := range counts {
+		p := float64(n) / float64(len(s))
+		entropy -= p * math.Log2(p)
+	}
+	return entropy >= minTokenEntropy
+}
+
+// redactingBackend is a Backend that redacts secrets in
+// requests before passing them to another Backend.
+type redactingBackend struct {
+	backend Backend
+	r       *redactor
+}
+
+// newRedactingBackend returns a Backend that redacts
+// secrets in requests with r before passing them to b.
+func newRedactingBackend(b Backend, r *redactor) *redactingBackend {
+	return &redactingBackend{
+		backend: b,
+		r:       r,
+	}
+}
+
+func (b *redactingBackend) Stream(ctx context.Context, req *Request) iter.Seq2[Event, error] {
+	n := len(b.r.secrets)
+	req = b.r.redactRequest(req)
+	if len(b.r.secrets) > n {
+		fmt.Printf("redacted %d secrets (%s)\n", len(b.r.secrets), strings.Join(b.r.kinds(), ", "))
+	}
+	return b.backend.Stream(ctx, req)
+}
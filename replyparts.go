@@ -9,22 +9,24 @@ import (
 
 	"github.com/go-json-experiment/json"
 	"github.com/go-json-experiment/json/jsontext"
-	"github.com/openai/openai-go/responses"
 )
 
+// partsIter returns an iterator over the reply parts in the
+// reply text produced by the given event stream.
+// All the reply text is written to save as it's read.
 func partsIter(
-	respIter iter.Seq2[responses.ResponseStreamEventUnion, error],
+	events iter.Seq2[Event, error],
 	save *bytes.Buffer,
 ) iter.Seq2[ReplyPart, error] {
 	return func(yield func(ReplyPart, error) bool) {
-		if err := yieldParts(respIter, yield, save); err != nil {
+		if err := yieldParts(events, yield, save); err != nil {
 			yield(ReplyPart{}, err)
 		}
 	}
 }
 
 func yieldParts(
-	respIter iter.Seq2[responses.ResponseStreamEventUnion, error],
+	events iter.Seq2[Event, error],
 	yield func(ReplyPart, error) bool,
 	save *bytes.Buffer,
 ) error {
@@ -34,7 +36,7 @@ func yieldParts(
 	// Ensure that all text is saved in case something goes wrong.
 	r := io.TeeReader(pr, save)
 
-	go writeResponseText(pw, respIter)
+	go writeResponseText(pw, events)
 	dec := jsontext.NewDecoder(r)
 
 	if err := expectToken(dec, '{', ""); err != nil {
@@ -49,8 +51,11 @@ func yieldParts(
 	for {
 		switch dec.PeekKind() {
 		case ']':
-			// Don't bother reading to the end; there's only one field.
-			return nil
+			// There's only one field, so don't bother parsing the
+			// rest, but read it anyway so that the stream
+			// runs to completion.
+			_, err := io.Copy(io.Discard, r)
+			return err
 		case 0:
 			_, err := dec.ReadToken()
 			return err
@@ -84,23 +89,24 @@ func expectToken(dec *jsontext.Decoder, kind jsontext.Kind, str string) error {
 	return nil
 }
 
-func writeResponseText(pw *io.PipeWriter, respIter iter.Seq2[responses.ResponseStreamEventUnion, error]) {
-	for ev, err := range respIter {
+func writeResponseText(pw *io.PipeWriter, events iter.Seq2[Event, error]) {
+	for ev, err := range events {
 		if err != nil {
 			if err == io.EOF {
 				err = io.ErrUnexpectedEOF
 			}
 			pw.CloseWithError(fmt.Errorf("streaming error: %w", err))
+			return
 		}
-		switch resp := ev.AsAny().(type) {
-		case responses.ResponseTextDeltaEvent:
-			if _, err := pw.Write([]byte(resp.Delta)); err != nil {
+		if ev.Text != "" {
+			if _, err := pw.Write([]byte(ev.Text)); err != nil {
 				return
 			}
-		case responses.ResponseTextDoneEvent:
-		case responses.ResponseCompletedEvent:
+		}
+		if ev.Done {
 			pw.Close()
 			return
 		}
 	}
+	pw.CloseWithError(fmt.Errorf("streaming error: %w", io.ErrUnexpectedEOF))
 }
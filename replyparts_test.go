@@ -0,0 +1,435 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeBackend is a Backend that produces the same
+// events for every request.
+type fakeBackend struct {
+	// texts holds the text of each event.
+	texts []string
+
+	// err, if non-nil, is produced after the text.
+	err error
+
+	// done reports whether the stream ends with a Done event.
+	done bool
+
+	// stopped, if non-nil, is closed when the consumer
+	// stops reading the stream early.
+	stopped chan struct{}
+}
+
+func (b *fakeBackend) Stream(ctx context.Context, req *Request) iter.Seq2[Event, error] {
+	return func(yield func(Event, error) bool) {
+		for _, text := range b.texts {
+			if !yield(Event{Text: text}, nil) {
+				if b.stopped != nil {
+					close(b.stopped)
+				}
+				return
+			}
+		}
+		switch {
+		case b.err != nil:
+			yield(Event{}, b.err)
+		case b.done:
+			yield(Event{Done: true, Usage: &Usage{InputTokens: 10, OutputTokens: 5}}, nil)
+		}
+	}
+}
+
+// partString returns a short description of p for
+// comparison in tests.
+func partString(p ReplyPart) string {
+	switch r := p.AsAny().(type) {
+	case *Commentary:
+		return "commentary:" + r.Text
+	case *SelectionReplace:
+		return "selectionReplace:" + r.Text
+	case *FullContent:
+		return "entire:" + r.FullContent
+	}
+	return p.Type()
+}
+
+var errStream = errors.New("connection reset")
+
+var partsIterTests = []struct {
+	name    string
+	backend fakeBackend
+
+	// skip reports whether to apply skipToJSON
+	// to the events.
+	skip bool
+
+	want    []string
+	wantErr string
+}{{
+	name: "simple",
+	backend: fakeBackend{
+		texts: []string{`{"parts":[{"type":"commentary","text":"hello"}]}`},
+		done:  true,
+	},
+	want: []string{"commentary:hello"},
+}, {
+	name: "fragmented",
+	backend: fakeBackend{
+		texts: []string{`{"pa`, `rts":[{"type":"comm`, `entary","text":"a\n`, `b"},`, `{"type":"selectionReplace",`, `"text":"x"}`, `]`, `}`},
+		done:  true,
+	},
+	want: []string{"commentary:a\nb", "selectionReplace:x"},
+}, {
+	name: "no parts",
+	backend: fakeBackend{
+		texts: []string{`{"parts":[]}`},
+		done:  true,
+	},
+}, {
+	name: "leading chatter",
+	backend: fakeBackend{
+		texts: []string{"Sure, here's the ", "edit:\n", `{"parts":[{"type":"entire","fullContent":"x"}]}`},
+		done:  true,
+	},
+	skip: true,
+	want: []string{"entire:x"},
+}, {
+	name: "code fences",
+	backend: fakeBackend{
+		texts: []string{"```json\n", `{"parts":[{"type":"commentary","text":"{}"}]}`, "\n```\n"},
+		done:  true,
+	},
+	skip: true,
+	want: []string{"commentary:{}"},
+}, {
+	name: "chatter without skipping",
+	backend: fakeBackend{
+		texts: []string{"```json\n", `{"parts":[]}`},
+		done:  true,
+	},
+	wantErr: "invalid character",
+}, {
+	name: "ends without Done",
+	backend: fakeBackend{
+		texts: []string{`{"parts":[{"type":"commentary","text":"hello"}`},
+	},
+	want:    []string{"commentary:hello"},
+	wantErr: "streaming error: unexpected EOF",
+}, {
+	name: "ends without Done after parts",
+	backend: fakeBackend{
+		texts: []string{`{"parts":[{"type":"commentary","text":"hello"}]}`},
+	},
+	want:    []string{"commentary:hello"},
+	wantErr: "streaming error: unexpected EOF",
+}, {
+	name: "error mid part",
+	backend: fakeBackend{
+		texts: []string{`{"parts":[{"type":"commentary","text":"hello"},{"type":"comm`},
+		err:   errStream,
+	},
+	want:    []string{"commentary:hello"},
+	wantErr: "streaming error: connection reset",
+}, {
+	name: "error after ]",
+	backend: fakeBackend{
+		texts: []string{`{"parts":[{"type":"commentary","text":"hello"}]`},
+		err:   errStream,
+	},
+	want:    []string{"commentary:hello"},
+	wantErr: "streaming error: connection reset",
+}, {
+	name: "EOF error",
+	backend: fakeBackend{
+		texts: []string{`{"parts":[`},
+		err:   io.EOF,
+	},
+	wantErr: "streaming error: unexpected EOF",
+}, {
+	name: "wrong field",
+	backend: fakeBackend{
+		texts: []string{`{"edits":[]}`},
+		done:  true,
+	},
+	wantErr: `want "parts" but got "edits"`,
+}, {
+	name: "not an object",
+	backend: fakeBackend{
+		texts: []string{`{"parts":[1]}`},
+		done:  true,
+	},
+	wantErr: "unexpected token",
+}}
+
+func TestPartsIter(t *testing.T) {
+	for _, test := range partsIterTests {
+		t.Run(test.name, func(t *testing.T) {
+			events := test.backend.Stream(context.Background(), &Request{})
+			if test.skip {
+				events = skipToJSON(events)
+			}
+			var saved bytes.Buffer
+			var got []string
+			var gotErr error
+			for part, err := range partsIter(events, &saved) {
+				if err != nil {
+					if gotErr != nil {
+						t.Errorf("second error %v after %v", err, gotErr)
+					}
+					gotErr = err
+					continue
+				}
+				if gotErr != nil {
+					t.Errorf("part %s after error", partString(part))
+				}
+				got = append(got, partString(part))
+			}
+			if !slices.Equal(got, test.want) {
+				t.Errorf("got parts %q; want %q", got, test.want)
+			}
+			switch {
+			case test.wantErr == "" && gotErr != nil:
+				t.Errorf("unexpected error: %v", gotErr)
+			case test.wantErr != "" && (gotErr == nil || !strings.Contains(gotErr.Error(), test.wantErr)):
+				t.Errorf("got error %v; want error containing %q", gotErr, test.wantErr)
+			}
+			// All the text that was read is saved.
+			text := strings.Join(test.backend.texts, "")
+			if test.skip {
+				text = text[strings.Index(text, "{"):]
+			}
+			if gotErr == nil && saved.String() != text {
+				t.Errorf("got saved text %q; want %q", saved.String(), text)
+			}
+		})
+	}
+}
+
+func TestPartsIterStopEarly(t *testing.T) {
+	backend := &fakeBackend{
+		texts: []string{
+			`{"parts":[{"type":"commentary","text":"a"},`,
+			`{"type":"commentary","text":"b"},`,
+			`{"type":"commentary","text":"c"}]}`,
+		},
+		done:    true,
+		stopped: make(chan struct{}),
+	}
+	var saved bytes.Buffer
+	for part, err := range partsIter(backend.Stream(context.Background(), &Request{}), &saved) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := partString(part); got != "commentary:a" {
+			t.Fatalf("got first part %q", got)
+		}
+		break
+	}
+	// The event stream must be stopped too.
+	select {
+	case <-backend.stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("event stream still running after consumer stopped")
+	}
+}
+
+var writeResponseTextTests = []struct {
+	name    string
+	backend fakeBackend
+	want    string
+	wantErr string
+}{{
+	name:    "done",
+	backend: fakeBackend{texts: []string{"a", "", "b"}, done: true},
+	want:    "ab",
+}, {
+	name:    "no done",
+	backend: fakeBackend{texts: []string{"a", "b"}},
+	want:    "ab",
+	wantErr: "streaming error: unexpected EOF",
+}, {
+	name:    "error",
+	backend: fakeBackend{texts: []string{"a"}, err: errStream},
+	want:    "a",
+	wantErr: "streaming error: connection reset",
+}, {
+	name:    "EOF",
+	backend: fakeBackend{texts: []string{"a"}, err: io.EOF},
+	want:    "a",
+	wantErr: "streaming error: unexpected EOF",
+}}
+
+func TestWriteResponseText(t *testing.T) {
+	for _, test := range writeResponseTextTests {
+		t.Run(test.name, func(t *testing.T) {
+			pr, pw := io.Pipe()
+			go writeResponseText(pw, test.backend.Stream(context.Background(), &Request{}))
+			got, err := io.ReadAll(pr)
+			if string(got) != test.want {
+				t.Errorf("got %q; want %q", got, test.want)
+			}
+			switch {
+			case test.wantErr == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case test.wantErr != "" && (err == nil || err.Error() != test.wantErr):
+				t.Errorf("got error %v; want %q", err, test.wantErr)
+			}
+		})
+	}
+}
+
+var skipToJSONTests = []struct {
+	name   string
+	events []Event
+	want   []Event
+}{{
+	name:   "no chatter",
+	events: []Event{{Text: `{"a":`}, {Text: `1}`}, {Done: true}},
+	want:   []Event{{Text: `{"a":`}, {Text: `1}`}, {Done: true}},
+}, {
+	name:   "chatter",
+	events: []Event{{Text: "Here"}, {Text: " it is: {"}, {Text: `"a":1}`}, {Done: true}},
+	want:   []Event{{Text: "{"}, {Text: `"a":1}`}, {Done: true}},
+}, {
+	name:   "later braces kept",
+	events: []Event{{Text: "```json\n{"}, {Text: `"a":"{"}`}, {Text: "\n```"}, {Done: true}},
+	want:   []Event{{Text: "{"}, {Text: `"a":"{"}`}, {Text: "\n```"}, {Done: true}},
+}, {
+	name:   "reasoning kept",
+	events: []Event{{Reasoning: "thinking", Text: "hmm"}, {Text: "{}"}, {Done: true}},
+	want:   []Event{{Reasoning: "thinking"}, {Text: "{}"}, {Done: true}},
+}, {
+	name:   "no JSON",
+	events: []Event{{Text: "sorry"}, {Done: true}},
+	want:   []Event{{Done: true}},
+}}
+
+// eventSeq returns an event stream producing the given events.
+func eventSeq(events []Event) iter.Seq2[Event, error] {
+	return func(yield func(Event, error) bool) {
+		for _, ev := range events {
+			if !yield(ev, nil) {
+				return
+			}
+		}
+	}
+}
+
+func TestSkipToJSON(t *testing.T) {
+	for _, test := range skipToJSONTests {
+		t.Run(test.name, func(t *testing.T) {
+			var got []Event
+			for ev, err := range skipToJSON(eventSeq(test.events)) {
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, ev)
+			}
+			if !slices.Equal(got, test.want) {
+				t.Errorf("got %+v; want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSkipToJSONError(t *testing.T) {
+	b := &fakeBackend{texts: []string{"chatter"}, err: errStream}
+	var got []error
+	for ev, err := range skipToJSON(b.Stream(context.Background(), &Request{})) {
+		if ev != (Event{}) {
+			t.Errorf("unexpected event %+v", ev)
+		}
+		got = append(got, err)
+	}
+	if len(got) != 1 || got[0] != errStream {
+		t.Errorf("got errors %v; want [%v]", got, errStream)
+	}
+}
+
+// fakeStream implements stream.
+type fakeStream struct {
+	items  []int
+	err    error
+	i      int
+	closed bool
+}
+
+func (s *fakeStream) Next() bool {
+	if s.i >= len(s.items) {
+		return false
+	}
+	s.i++
+	return true
+}
+
+func (s *fakeStream) Current() int { return s.items[s.i-1] }
+func (s *fakeStream) Err() error {
+	if s.i < len(s.items) {
+		return nil
+	}
+	return s.err
+}
+func (s *fakeStream) Close() { s.closed = true }
+
+var streamIterTests = []struct {
+	name   string
+	stream fakeStream
+	stop   int
+	want   []string
+	closed bool
+}{{
+	name:   "all",
+	stream: fakeStream{items: []int{1, 2, 3}},
+	want:   []string{"1", "2", "3"},
+}, {
+	name:   "trailing error",
+	stream: fakeStream{items: []int{1, 2}, err: errStream},
+	// The error is reported with the last item
+	// and again at the end.
+	want: []string{"1", "2 connection reset", "0 connection reset"},
+}, {
+	name:   "error only",
+	stream: fakeStream{err: errStream},
+	want:   []string{"0 connection reset"},
+}, {
+	name:   "stop early",
+	stream: fakeStream{items: []int{1, 2, 3}, err: errStream},
+	stop:   1,
+	want:   []string{"1"},
+	closed: true,
+}}
+
+func TestStreamIter(t *testing.T) {
+	for _, test := range streamIterTests {
+		t.Run(test.name, func(t *testing.T) {
+			s := test.stream
+			var got []string
+			for item, err := range streamIter(stream[int](&s)) {
+				if err != nil {
+					got = append(got, fmt.Sprintf("%d %v", item, err))
+				} else {
+					got = append(got, fmt.Sprint(item))
+				}
+				if len(got) == test.stop {
+					break
+				}
+			}
+			if !slices.Equal(got, test.want) {
+				t.Errorf("got %q; want %q", got, test.want)
+			}
+			if s.closed != test.closed {
+				t.Errorf("got closed %v; want %v", s.closed, test.closed)
+			}
+		})
+	}
+}
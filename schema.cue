@@ -84,4 +84,8 @@ package main
 	// content holds the actual content. If base64 is true, this
 	// will be base64-encoded.
 	content!: string
+	// mediaType holds the media type of an image or PDF document.
+	// Such content is attached separately, following the part,
+	// and content is empty.
+	mediaType?: string
 }
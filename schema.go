@@ -4,8 +4,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"reflect"
+	"slices"
+	"strings"
 
-	"github.com/sashabaranov/go-openai"
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/cuecontext"
+	"cuelang.org/go/encoding/jsonschema"
 )
 
 var replyTypes = map[string]reflect.Type{
@@ -18,6 +22,7 @@ var replyTypes = map[string]reflect.Type{
 }
 
 type replyPart struct {
+	typ     string
 	content any
 }
 
@@ -25,6 +30,12 @@ func (r *replyPart) AsAny() any {
 	return r.content
 }
 
+// Type returns the type of the reply part,
+// as held in its type field.
+func (r *replyPart) Type() string {
+	return r.typ
+}
+
 func (r *replyPart) UnmarshalJSON(data []byte) error {
 	var gr GenericReply
 	if err := json.Unmarshal(data, &gr); err != nil {
@@ -38,17 +49,136 @@ func (r *replyPart) UnmarshalJSON(data []byte) error {
 	if err := json.Unmarshal(data, actual); err != nil {
 		return err
 	}
-	r.content = actual
+	r.typ, r.content = gr.Type, actual
 	return nil
 }
 
-func (p Part) AsOpenAI() (openai.ChatMessagePart, error) {
-	data, err := json.Marshal(p)
+// text returns the text that represents p in a request:
+// its JSON encoding, without the HTML escaping
+// that would waste tokens.
+func (p Part) text() (string, error) {
+	var buf strings.Builder
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(p); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+// replySchema returns a JSON Schema for #Reply derived from
+// schemaCUE, suitable for use as a strict structured output
+// format. In strict mode, OpenAI requires that all properties
+// are required and that objects are closed, and
+// it doesn't allow $ref alongside other keywords.
+//
+// If allowed is non-empty, the reply may only hold parts
+// of the given types.
+func replySchema(allowed []ReplyType) (map[string]any, error) {
+	ctx := cuecontext.New()
+	v := ctx.CompileString(schemaCUE)
+	if err := v.Err(); err != nil {
+		return nil, fmt.Errorf("cannot compile schema: %v", err)
+	}
+	expr, err := jsonschema.Generate(v.LookupPath(cue.ParsePath("#Reply")), &jsonschema.GenerateConfig{
+		NameFunc: func(root cue.Value, path cue.Path) string {
+			return strings.TrimPrefix(path.String(), "#")
+		},
+	})
 	if err != nil {
-		return openai.ChatMessagePart{}, err
+		return nil, fmt.Errorf("cannot generate JSON Schema: %v", err)
+	}
+	data, err := ctx.BuildExpr(expr).MarshalJSON()
+	if err != nil {
+		return nil, fmt.Errorf("cannot marshal JSON Schema: %v", err)
+	}
+	var schema map[string]any
+	if err := json.Unmarshal(data, &schema); err != nil {
+		return nil, err
+	}
+	delete(schema, "$schema")
+	makeStrict(schema)
+
+	defs, _ := schema["$defs"].(map[string]any)
+	if len(allowed) > 0 {
+		restrictReplyParts(defs, allowed)
+	}
+
+	// Remove definitions that are no longer referenced,
+	// such as #GenericReply.
+	refs := make(map[string]bool)
+	walkSchema(schema, func(m map[string]any) {
+		if ref, ok := m["$ref"].(string); ok {
+			refs[strings.TrimPrefix(ref, "#/$defs/")] = true
+		}
+	})
+	for name := range defs {
+		if !refs[name] {
+			delete(defs, name)
+		}
+	}
+	return schema, nil
+}
+
+// restrictReplyParts modifies the #ReplyPart definition
+// in defs so that only the allowed types of part are permitted.
+func restrictReplyParts(defs map[string]any, allowed []ReplyType) {
+	names := make(map[string]bool)
+	for _, typ := range allowed {
+		if t := replyTypes[string(typ)]; t != nil {
+			names["#/$defs/"+t.Name()] = true
+		}
+	}
+	replyPart, _ := defs["ReplyPart"].(map[string]any)
+	anyOf, _ := replyPart["anyOf"].([]any)
+	replyPart["anyOf"] = slices.DeleteFunc(anyOf, func(e any) bool {
+		ref, _ := e.(map[string]any)["$ref"].(string)
+		return !names[ref]
+	})
+}
+
+// makeStrict modifies the given schema to conform
+// to the restrictions of strict mode.
+func makeStrict(schema map[string]any) {
+	walkSchema(schema, func(m map[string]any) {
+		if c, ok := m["const"]; ok {
+			delete(m, "const")
+			m["enum"] = []any{c}
+			if _, ok := c.(string); ok {
+				m["type"] = "string"
+			}
+		}
+		props, ok := m["properties"].(map[string]any)
+		if !ok {
+			return
+		}
+		// Any $ref alongside properties refers to
+		// an embedded definition such as #GenericReply
+		// that the properties already cover.
+		delete(m, "$ref")
+		required := make([]any, 0, len(props))
+		for name := range props {
+			required = append(required, name)
+		}
+		slices.SortFunc(required, func(a, b any) int {
+			return strings.Compare(a.(string), b.(string))
+		})
+		m["required"] = required
+		m["additionalProperties"] = false
+	})
+}
+
+// walkSchema calls f on every JSON object in the given schema.
+func walkSchema(v any, f func(map[string]any)) {
+	switch v := v.(type) {
+	case map[string]any:
+		f(v)
+		for _, e := range v {
+			walkSchema(e, f)
+		}
+	case []any:
+		for _, e := range v {
+			walkSchema(e, f)
+		}
 	}
-	return openai.ChatMessagePart{
-		Type: openai.ChatMessagePartTypeText,
-		Text: string(data),
-	}, nil
 }
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// sessionExpiry holds how long a session lasts
+	// after it was last used.
+	sessionExpiry = 24 * time.Hour
+
+	// maxSessionMessages holds the maximum number of
+	// messages from earlier invocations that are sent
+	// with a new request.
+	maxSessionMessages = 20
+)
+
+// session holds the conversation associated
+// with a file in an acme window.
+type session struct {
+	Winid    string    `json:"winid"`
+	Filename string    `json:"filename"`
+	Updated  time.Time `json:"updated"`
+	Messages []Message `json:"messages"`
+
+	// RedactKey holds the key used to derive placeholders
+	// for redacted secrets, so that they stay the same
+	// throughout the session.
+	RedactKey []byte `json:"redactKey,omitempty"`
+
+	path string
+}
+
+// loadSession loads the session for the given acme window
+// and file, pruning any expired sessions. If there's no
+// current session, it returns a new empty session.
+func loadSession(winid, filename string) (*session, error) {
+	dir, err := sessionDir()
+	if err != nil {
+		return nil, err
+	}
+	pruneSessions(dir)
+	s := &session{
+		Winid:    winid,
+		Filename: filename,
+		path:     filepath.Join(dir, sessionKey(winid, filename)+".json"),
+	}
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read session: %v", err)
+	}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("cannot unmarshal session %s: %v", s.path, err)
+	}
+	if time.Since(s.Updated) > sessionExpiry {
+		s.Messages = nil
+	}
+	return s, nil
+}
+
+// redactKey returns the key used to derive placeholders
+// for secrets redacted in the session, creating it if needed.
+func (s *session) redactKey() []byte {
+	if s.RedactKey == nil {
+		s.RedactKey = make([]byte, 32)
+		if _, err := rand.Read(s.RedactKey); err != nil {
+			panic(err)
+		}
+	}
+	return s.RedactKey
+}
+
+// history returns the messages from the session
+// to be sent before a new request.
+func (s *session) history() []Message {
+	msgs := s.Messages
+	if len(msgs) > maxSessionMessages {
+		msgs = msgs[len(msgs)-maxSessionMessages:]
+	}
+	// The conversation must start with a user message.
+	for len(msgs) > 0 && msgs[0].Role != "user" {
+		msgs = msgs[1:]
+	}
+	return msgs
+}
+
+// trimHistory returns msgs with the oldest turns of the
+// conversation dropped until fits reports true for the
+// remaining messages or there are none left.
+func trimHistory(msgs []Message, fits func([]Message) (bool, error)) ([]Message, error) {
+	for len(msgs) > 0 {
+		ok, err := fits(msgs)
+		if err != nil || ok {
+			return msgs, err
+		}
+		// The conversation must still start with a user message.
+		msgs = msgs[1:]
+		for len(msgs) > 0 && msgs[0].Role != "user" {
+			msgs = msgs[1:]
+		}
+	}
+	return msgs, nil
+}
+
+// save saves the session with the given messages.
+func (s *session) save(msgs []Message) error {
+	s.Messages = msgs
+	s.Updated = time.Now()
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+		return fmt.Errorf("cannot create session directory: %v", err)
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("cannot write session: %v", err)
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// writeTranscript writes a human-readable transcript of
+// the session to w.
+func (s *session) writeTranscript(w io.Writer) error {
+	if len(s.Messages) == 0 {
+		_, err := fmt.Fprintf(w, "no session for %s in window %s\n", s.Filename, s.Winid)
+		return err
+	}
+	for _, m := range s.Messages {
+		if m.Role == "user" {
+			fmt.Fprintf(w, "-- user\n")
+			for _, p := range m.Parts {
+				if p.Filename != "" {
+					fmt.Fprintf(w, "[%s (%d bytes)]\n", p.Filename, len(p.Content))
+				} else {
+					fmt.Fprintf(w, "%s\n", p.Content)
+				}
+			}
+			continue
+		}
+		fmt.Fprintf(w, "-- assistant\n")
+		var reply Reply
+		if err := json.Unmarshal([]byte(m.Text), &reply); err != nil {
+			fmt.Fprintf(w, "%s\n", m.Text)
+			continue
+		}
+		for _, part := range reply.Parts {
+			switch r := part.AsAny().(type) {
+			case *FurtherInstructionNeeded:
+				fmt.Fprintf(w, "further instruction needed: %s\n", r.Message)
+			case *FullContent:
+				fmt.Fprintf(w, "[entire file (%d bytes)]\n", len(r.FullContent))
+			case *SelectionAppend:
+				fmt.Fprintf(w, "[append to selection]\n%s\n", r.Text)
+			case *SelectionInsert:
+				fmt.Fprintf(w, "[insert before selection]\n%s\n", r.Text)
+			case *SelectionReplace:
+				fmt.Fprintf(w, "[replace selection]\n%s\n", r.Text)
+			case *Commentary:
+				fmt.Fprintf(w, "%s\n", r.Text)
+			}
+		}
+	}
+	return nil
+}
+
+// pruneSessions removes all expired sessions from dir.
+func pruneSessions(dir string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil || time.Since(info.ModTime()) < sessionExpiry {
+			continue
+		}
+		os.Remove(filepath.Join(dir, e.Name()))
+	}
+}
+
+func sessionDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("cannot find cache directory: %v", err)
+	}
+	return filepath.Join(dir, "AI", "sessions"), nil
+}
+
+func sessionKey(winid, filename string) string {
+	sum := sha256.Sum256([]byte(winid + "\x00" + filename))
+	return fmt.Sprintf("%x", sum[:12])
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestTrimHistory(t *testing.T) {
+	msgs := []Message{
+		{Role: "user", Text: "u1"},
+		{Role: "assistant", Text: "a1"},
+		{Role: "user", Text: "u2"},
+		{Role: "assistant", Text: "a2"},
+		{Role: "user", Text: "u3"},
+		{Role: "assistant", Text: "a3"},
+	}
+	texts := func(msgs []Message) string {
+		var s []string
+		for _, m := range msgs {
+			s = append(s, m.Text)
+		}
+		return strings.Join(s, " ")
+	}
+	for _, test := range []struct {
+		max  int
+		want string
+	}{
+		{6, "u1 a1 u2 a2 u3 a3"},
+		{5, "u2 a2 u3 a3"},
+		{4, "u2 a2 u3 a3"},
+		{2, "u3 a3"},
+		{1, ""},
+	} {
+		got, err := trimHistory(slices.Clone(msgs), func(msgs []Message) (bool, error) {
+			if len(msgs) > 0 && msgs[0].Role != "user" {
+				t.Errorf("history starts with %s message", msgs[0].Role)
+			}
+			return len(msgs) <= test.max, nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := texts(got); got != test.want {
+			t.Errorf("max %d: got %q; want %q", test.max, got, test.want)
+		}
+	}
+}
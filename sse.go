@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"iter"
+	"strings"
+)
+
+// sseEvent holds a single server-sent event.
+type sseEvent struct {
+	Event string
+	Data  string
+}
+
+// sseEvents returns an iterator over the server-sent events read from r.
+// See https://html.spec.whatwg.org/multipage/server-sent-events.html.
+func sseEvents(r io.Reader) iter.Seq2[sseEvent, error] {
+	return func(yield func(sseEvent, error) bool) {
+		scan := bufio.NewScanner(r)
+		scan.Buffer(nil, 1024*1024)
+		var ev sseEvent
+		var data strings.Builder
+		hasData := false
+		for scan.Scan() {
+			line := scan.Text()
+			if line == "" {
+				if hasData {
+					ev.Data = data.String()
+					if !yield(ev, nil) {
+						return
+					}
+				}
+				ev = sseEvent{}
+				data.Reset()
+				hasData = false
+				continue
+			}
+			if strings.HasPrefix(line, ":") {
+				// Comment.
+				continue
+			}
+			field, value, _ := strings.Cut(line, ":")
+			value = strings.TrimPrefix(value, " ")
+			switch field {
+			case "event":
+				ev.Event = value
+			case "data":
+				if hasData {
+					data.WriteByte('\n')
+				}
+				data.WriteString(value)
+				hasData = true
+			}
+		}
+		if err := scan.Err(); err != nil {
+			yield(sseEvent{}, err)
+			return
+		}
+		if hasData {
+			ev.Data = data.String()
+			yield(ev, nil)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+var sseEventsTests = []struct {
+	name  string
+	input string
+	want  []sseEvent
+}{{
+	name:  "single",
+	input: "event: ping\ndata: {}\n\n",
+	want:  []sseEvent{{Event: "ping", Data: "{}"}},
+}, {
+	name:  "multi-line data",
+	input: "data: first\ndata: second\ndata:third\n\n",
+	want:  []sseEvent{{Data: "first\nsecond\nthird"}},
+}, {
+	name:  "comments",
+	input: ": keep-alive\nevent: a\n: another comment\ndata: 1\n\n:\n\n",
+	want:  []sseEvent{{Event: "a", Data: "1"}},
+}, {
+	name:  "no trailing blank line",
+	input: "event: a\ndata: 1\n\nevent: b\ndata: 2",
+	want:  []sseEvent{{Event: "a", Data: "1"}, {Event: "b", Data: "2"}},
+}, {
+	name:  "event without data",
+	input: "event: a\n\nevent: b\ndata: 2\n\n",
+	want:  []sseEvent{{Event: "b", Data: "2"}},
+}, {
+	name:  "unknown fields",
+	input: "id: 7\nretry: 100\ndata: x\n\n",
+	want:  []sseEvent{{Data: "x"}},
+}}
+
+func TestSSEEvents(t *testing.T) {
+	for _, test := range sseEventsTests {
+		t.Run(test.name, func(t *testing.T) {
+			var got []sseEvent
+			for ev, err := range sseEvents(strings.NewReader(test.input)) {
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, ev)
+			}
+			if !slices.Equal(got, test.want) {
+				t.Errorf("got %q; want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSSEEventsReadError(t *testing.T) {
+	errBroken := errors.New("broken")
+	var got []sseEvent
+	var gotErr error
+	for ev, err := range sseEvents(io.MultiReader(strings.NewReader("data: 1\n\ndata: 2\n"), iotest.ErrReader(errBroken))) {
+		if err != nil {
+			gotErr = err
+			break
+		}
+		got = append(got, ev)
+	}
+	if !errors.Is(gotErr, errBroken) {
+		t.Errorf("got error %v; want %v", gotErr, errBroken)
+	}
+	// The incomplete event isn't produced.
+	if want := []sseEvent{{Data: "1"}}; !slices.Equal(got, want) {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"iter"
+	"strings"
+)
+
+// bytesPerToken holds the approximate number of bytes
+// per token for typical source code and prose.
+const bytesPerToken = 4
+
+// estimateTokens returns an estimate of the number
+// of tokens that s will be encoded as.
+func estimateTokens(s string) int {
+	return (len(s) + bytesPerToken - 1) / bytesPerToken
+}
+
+// mediaTokens holds a rough estimate of the number of
+// tokens used by an image or a short document.
+const mediaTokens = 1500
+
+// estimateRequestTokens returns an estimate of the
+// number of input tokens used by req.
+func estimateRequestTokens(req *Request) (int, error) {
+	n := estimateTokens(req.System)
+	for _, m := range req.Messages {
+		text, err := messageText(m)
+		if err != nil {
+			return 0, err
+		}
+		n += estimateTokens(text)
+		for _, p := range m.Parts {
+			if p.MediaType != "" {
+				n += mediaTokens
+			}
+		}
+	}
+	return n, nil
+}
+
+// modelPrice holds the price of a model in dollars
+// per million tokens.
+type modelPrice struct {
+	input, cached, output float64
+}
+
+// modelPrices holds the prices of some well known models,
+// keyed by model name prefix.
+var modelPrices = map[string]modelPrice{
+	"gpt-4o":            {2.50, 1.25, 10},
+	"gpt-4o-mini":       {0.15, 0.075, 0.60},
+	"gpt-4.1":           {2, 0.50, 8},
+	"gpt-4.1-mini":      {0.40, 0.10, 1.60},
+	"gpt-4.1-nano":      {0.10, 0.025, 0.40},
+	"gpt-5":             {1.25, 0.125, 10},
+	"gpt-5-mini":        {0.25, 0.025, 2},
+	"o3":                {2, 0.50, 8},
+	"o4-mini":           {1.10, 0.275, 4.40},
+	"claude-sonnet-4":   {3, 0.30, 15},
+	"claude-opus-4":     {15, 1.50, 75},
+	"claude-haiku-4":    {1, 0.10, 5},
+	"claude-3-5-haiku":  {0.80, 0.08, 4},
+	"claude-3-7-sonnet": {3, 0.30, 15},
+}
+
+// estimateCost returns the estimated cost in dollars of the
+// given usage with the given model. It reports false
+// if the price of the model isn't known.
+func estimateCost(model string, u Usage) (float64, bool) {
+	var price modelPrice
+	found := ""
+	for prefix, p := range modelPrices {
+		if strings.HasPrefix(model, prefix) && len(prefix) > len(found) {
+			price, found = p, prefix
+		}
+	}
+	if found == "" {
+		return 0, false
+	}
+	cost := float64(u.InputTokens-u.CachedTokens)*price.input +
+		float64(u.CachedTokens)*price.cached +
+		float64(u.OutputTokens)*price.output
+	return cost / 1e6, true
+}
+
+// printUsage prints the given token usage and its estimated cost.
+func printUsage(w io.Writer, model string, u Usage) {
+	fmt.Fprintf(w, "tokens: input %d (cached %d), output %d (reasoning %d)", u.InputTokens, u.CachedTokens, u.OutputTokens, u.ReasoningTokens)
+	if cost, ok := estimateCost(model, u); ok {
+		fmt.Fprintf(w, "; estimated cost $%.4f", cost)
+	}
+	fmt.Fprintf(w, "\n")
+}
+
+// addUsage returns events, adding the token usage reported
+// by any completed reply to u.
+func addUsage(events iter.Seq2[Event, error], u *Usage) iter.Seq2[Event, error] {
+	return func(yield func(Event, error) bool) {
+		for ev, err := range events {
+			if ev.Usage != nil {
+				u.add(*ev.Usage)
+			}
+			if !yield(ev, err) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxToolRounds holds the maximum number of times
+// a backend will call tools before giving up.
+const maxToolRounds = 10
+
+// Tool represents a function that the model can call
+// while it's producing its reply.
+type Tool struct {
+	Name        string
+	Description string
+
+	// Parameters holds the JSON Schema for the arguments
+	// to the tool. It must conform to the restrictions of
+	// strict mode (see makeStrict).
+	Parameters map[string]any
+
+	// Call calls the tool with the given JSON-encoded
+	// arguments and returns the result to send to the model.
+	Call func(args []byte) (string, error)
+}
+
+// callTool calls the named tool with the given arguments and
+// returns the result to send to the model. Errors are reported
+// to the model rather than causing the request to fail.
+func callTool(tools []*Tool, name, args string) string {
+	for _, t := range tools {
+		if t.Name != name {
+			continue
+		}
+		result, err := t.Call([]byte(args))
+		if err != nil {
+			fmt.Printf("%s: %v\n", name, err)
+			return fmt.Sprintf("error: %v", err)
+		}
+		return result
+	}
+	return fmt.Sprintf("error: no such tool %q", name)
+}
+
+// fileTools returns tools that let the model read files
+// and list directories within the directory tree rooted
+// at root. If limit is non-negative, no more than limit bytes
+// in total will be read. Files for which sensitive returns
+// true can't be read.
+func fileTools(root string, limit int, sensitive func(filename string) bool) []*Tool {
+	ft := &fileTooler{
+		root:      root,
+		limit:     limit,
+		sensitive: sensitive,
+	}
+	pathParam := map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"path": map[string]any{
+				"type":        "string",
+				"description": "Slash-separated path relative to the directory holding the file being edited.",
+			},
+		},
+		"required":             []any{"path"},
+		"additionalProperties": false,
+	}
+	return []*Tool{{
+		Name:        "read_file",
+		Description: "Read the contents of a file.",
+		Parameters:  pathParam,
+		Call:        ft.readFile,
+	}, {
+		Name:        "list_dir",
+		Description: "List the contents of a directory. Directory names are shown with a trailing slash.",
+		Parameters:  pathParam,
+		Call:        ft.listDir,
+	}}
+}
+
+type fileTooler struct {
+	root      string
+	limit     int
+	total     int
+	sensitive func(filename string) bool
+}
+
+type pathArgs struct {
+	Path string `json:"path"`
+}
+
+func (ft *fileTooler) readFile(args []byte) (string, error) {
+	name, path, err := ft.resolve(args)
+	if err != nil {
+		return "", err
+	}
+	if ft.isSensitive(name, path) {
+		return "", fmt.Errorf("refusing to read %s: it's a sensitive file", ft.rel(path))
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	if !utf8.Valid(data) {
+		return "", fmt.Errorf("%s is not a text file", ft.rel(path))
+	}
+	if ft.limit >= 0 && ft.total+len(data) > ft.limit {
+		return "", fmt.Errorf("refusing to read %s (%d bytes): total size limit exceeded", ft.rel(path), len(data))
+	}
+	ft.total += len(data)
+	fmt.Printf("read_file %s (%d bytes)\n", ft.rel(path), len(data))
+	return string(data), nil
+}
+
+func (ft *fileTooler) listDir(args []byte) (string, error) {
+	_, path, err := ft.resolve(args)
+	if err != nil {
+		return "", err
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return "", err
+	}
+	var buf strings.Builder
+	for _, e := range entries {
+		buf.WriteString(e.Name())
+		if e.IsDir() {
+			buf.WriteString("/")
+		}
+		buf.WriteString("\n")
+	}
+	fmt.Printf("list_dir %s\n", ft.rel(path))
+	return buf.String(), nil
+}
+
+// resolve returns the name of the file named by the given
+// arguments, inside the root directory, and its path with
+// symbolic links evaluated, making sure that the latter is
+// inside the root directory too.
+func (ft *fileTooler) resolve(args []byte) (name, path string, _ error) {
+	var a pathArgs
+	if err := json.Unmarshal(args, &a); err != nil {
+		return "", "", fmt.Errorf("invalid arguments: %v", err)
+	}
+	if a.Path == "" {
+		a.Path = "."
+	}
+	if !filepath.IsLocal(filepath.FromSlash(a.Path)) {
+		return "", "", fmt.Errorf("%q is outside the permitted directory", a.Path)
+	}
+	root, err := filepath.EvalSymlinks(ft.root)
+	if err != nil {
+		return "", "", err
+	}
+	name = filepath.Join(ft.root, filepath.FromSlash(a.Path))
+	path, err = filepath.EvalSymlinks(filepath.Join(root, filepath.FromSlash(a.Path)))
+	if err != nil {
+		return "", "", err
+	}
+	// Check again in case a symbolic link points elsewhere.
+	if rel, err := filepath.Rel(root, path); err != nil || !filepath.IsLocal(rel) {
+		return "", "", fmt.Errorf("%q is outside the permitted directory", a.Path)
+	}
+	return name, path, nil
+}
+
+// isSensitive reports whether the file with the given name
+// and resolved path is sensitive. The sensitive file patterns
+// are relative to the root as named, which might itself be
+// reached through a symbolic link, so the resolved path is
+// checked both as it is and relative to the root as named.
+func (ft *fileTooler) isSensitive(name, path string) bool {
+	return ft.sensitive(name) ||
+		ft.sensitive(path) ||
+		ft.sensitive(filepath.Join(ft.root, filepath.FromSlash(ft.rel(path))))
+}
+
+func (ft *fileTooler) rel(path string) string {
+	root, _ := filepath.EvalSymlinks(ft.root)
+	if rel, err := filepath.Rel(root, path); err == nil {
+		return filepath.ToSlash(rel)
+	}
+	return path
+}
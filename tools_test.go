@@ -0,0 +1,186 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// toolTree creates a project directory reached through a
+// symbolic link, as it might be on macOS, where /tmp is a
+// link to /private/tmp, and returns the project directory
+// as named through the link.
+func toolTree(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	real := filepath.Join(dir, "real")
+	files := map[string]string{
+		"real/proj/a.txt":      "hello\n",
+		"real/proj/sub/b.txt":  "world\n",
+		"real/proj/.env":       "KEY=x\n",
+		"real/proj/secrets/k":  "secret\n",
+		"real/proj/binary.dat": "\xff\xfe\x00",
+		"outside.txt":          "outside\n",
+	}
+	for name, content := range files {
+		name = filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(name), 0o777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(content), 0o666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := map[string]string{
+		"link":             real,
+		"real/proj/escape": filepath.Join(dir, "outside.txt"),
+		"real/proj/up":     "../..",
+		"real/proj/alias":  "secrets/k",
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(dir, filepath.FromSlash(name))); err != nil {
+			t.Skipf("cannot create symbolic link: %v", err)
+		}
+	}
+	return filepath.Join(dir, "link", "proj")
+}
+
+var resolveTests = []struct {
+	path     string
+	wantName string
+	wantPath string
+	wantErr  string
+}{{
+	path:     "a.txt",
+	wantName: "a.txt",
+	wantPath: "a.txt",
+}, {
+	path:     "sub/../sub/b.txt",
+	wantName: "sub/b.txt",
+	wantPath: "sub/b.txt",
+}, {
+	path:     "",
+	wantName: ".",
+	wantPath: ".",
+}, {
+	path:     "alias",
+	wantName: "alias",
+	wantPath: "secrets/k",
+}, {
+	path:    "../outside.txt",
+	wantErr: "outside the permitted directory",
+}, {
+	path:    "sub/../../outside.txt",
+	wantErr: "outside the permitted directory",
+}, {
+	path:    "/etc/passwd",
+	wantErr: "outside the permitted directory",
+}, {
+	path:    "escape",
+	wantErr: "outside the permitted directory",
+}, {
+	path:    "up/outside.txt",
+	wantErr: "outside the permitted directory",
+}, {
+	path:    "missing.txt",
+	wantErr: "no such file",
+}}
+
+func TestFileToolerResolve(t *testing.T) {
+	root := toolTree(t)
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ft := &fileTooler{root: root, limit: -1}
+	for _, test := range resolveTests {
+		t.Run(test.path, func(t *testing.T) {
+			name, path, err := ft.resolve([]byte(`{"path":"` + test.path + `"}`))
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("got error %v; want error containing %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := filepath.Join(root, filepath.FromSlash(test.wantName)); name != want {
+				t.Errorf("got name %q; want %q", name, want)
+			}
+			if want := filepath.Join(realRoot, filepath.FromSlash(test.wantPath)); path != want {
+				t.Errorf("got path %q; want %q", path, want)
+			}
+		})
+	}
+}
+
+var readFileTests = []struct {
+	path    string
+	want    string
+	wantErr string
+}{{
+	path: "a.txt",
+	want: "hello\n",
+}, {
+	path:    "../outside.txt",
+	wantErr: "outside the permitted directory",
+}, {
+	path:    "escape",
+	wantErr: "outside the permitted directory",
+}, {
+	path:    ".env",
+	wantErr: "sensitive",
+}, {
+	// The pattern holds a directory, relative to
+	// the root as named through the link.
+	path:    "secrets/k",
+	wantErr: "sensitive",
+}, {
+	path:    "alias",
+	wantErr: "sensitive",
+}, {
+	path:    "binary.dat",
+	wantErr: "not a text file",
+}}
+
+func TestReadFile(t *testing.T) {
+	root := toolTree(t)
+	cfg := &Config{Sensitive: []string{".env", "secrets/*"}}
+	cfg.resolve(root)
+	ft := &fileTooler{root: root, limit: -1, sensitive: cfg.sensitive}
+	for _, test := range readFileTests {
+		t.Run(test.path, func(t *testing.T) {
+			got, err := ft.readFile([]byte(`{"path":"` + test.path + `"}`))
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("got %q, error %v; want error containing %q", got, err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("got %q; want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestReadFileLimit(t *testing.T) {
+	root := toolTree(t)
+	ft := &fileTooler{root: root, limit: 10, sensitive: func(string) bool { return false }}
+	if _, err := ft.readFile([]byte(`{"path":"a.txt"}`)); err != nil {
+		t.Fatal(err)
+	}
+	// Both files together are over the limit.
+	if _, err := ft.readFile([]byte(`{"path":"sub/b.txt"}`)); err == nil || !strings.Contains(err.Error(), "limit exceeded") {
+		t.Fatalf("got error %v; want limit exceeded", err)
+	}
+	// A refused read doesn't count.
+	if ft.total != len("hello\n") {
+		t.Errorf("got total %d; want %d", ft.total, len("hello\n"))
+	}
+}
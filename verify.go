@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// checkTimeout holds the maximum time that
+// a single check command may run for.
+const checkTimeout = 5 * time.Minute
+
+// maxCheckTreeSize holds the maximum total size of the files
+// copied to make the scratch copy used by runChecks.
+const maxCheckTreeSize = 256 << 20
+
+// checkResult holds the result of running a set of check commands.
+type checkResult struct {
+	// passed holds the commands that succeeded.
+	passed []string
+
+	// failure holds the output of the commands that failed.
+	// It's empty when all the commands succeeded.
+	failure string
+}
+
+// runChecks runs each of the given shell commands in a scratch
+// copy of the module containing filename, or just of the directory
+// containing filename if it's not inside a module, with text as the
+// contents of filename. Commands are run in the root directory
+// of the copy.
+func runChecks(filename string, text []byte, checks []string) (*checkResult, error) {
+	root, inModule := moduleRoot(filepath.Dir(filename))
+	rel, err := filepath.Rel(root, filename)
+	if err != nil {
+		return nil, err
+	}
+	scratch, err := os.MkdirTemp("", "AI-check-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(scratch)
+	if err := copyTree(scratch, root, inModule); err != nil {
+		return nil, fmt.Errorf("cannot make scratch copy of %s: %v", root, err)
+	}
+	if err := os.WriteFile(filepath.Join(scratch, rel), text, 0o666); err != nil {
+		return nil, err
+	}
+	var result checkResult
+	var failure strings.Builder
+	for _, check := range checks {
+		ctx, cancel := context.WithTimeout(context.Background(), checkTimeout)
+		cmd := exec.CommandContext(ctx, "sh", "-c", check)
+		cmd.Dir = scratch
+		out, err := cmd.CombinedOutput()
+		cancel()
+		if err == nil {
+			result.passed = append(result.passed, check)
+			continue
+		}
+		// Make the output refer to the real files rather than the copies.
+		out = bytes.ReplaceAll(out, []byte(scratch), []byte(root))
+		fmt.Fprintf(&failure, "$ %s\n%s(%v)\n", check, out, err)
+	}
+	result.failure = failure.String()
+	return &result, nil
+}
+
+// moduleRoot returns the root directory of the Go module
+// containing dir and true, or dir itself and false if it's
+// not inside a module.
+func moduleRoot(dir string) (string, bool) {
+	for d := dir; ; {
+		if _, err := os.Stat(filepath.Join(d, "go.mod")); err == nil {
+			return d, true
+		}
+		parent := filepath.Dir(d)
+		if parent == d {
+			return dir, false
+		}
+		d = parent
+	}
+}
+
+// copyTree copies the regular files and symbolic links in the
+// directory src to dst, including those in subdirectories if
+// recursive is true. Version control directories are ignored,
+// as are directories holding code that isn't part of the project,
+// except for the vendor directory at the top, which the go command
+// uses. It fails if the files would take more than
+// maxCheckTreeSize bytes.
+func copyTree(dst, src string, recursive bool) error {
+	size := 0
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		switch {
+		case d.IsDir():
+			if rel == "." {
+				return nil
+			}
+			if !recursive ||
+				d.Name() == ".git" || d.Name() == ".hg" ||
+				isSkippedDir(d.Name()) && rel != "vendor" {
+				return filepath.SkipDir
+			}
+			return os.MkdirAll(target, 0o777)
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case d.Type().IsRegular():
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			size += int(info.Size())
+			if size > maxCheckTreeSize {
+				return fmt.Errorf("more than %d bytes to copy", maxCheckTreeSize)
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			return os.WriteFile(target, data, info.Mode().Perm())
+		}
+		return nil
+	})
+}
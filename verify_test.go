@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeFiles creates the given files, keyed by slash-separated
+// name, inside dir.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, data := range files {
+		name = filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(name), 0o777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(data), 0o666); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRunChecks(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"go.mod":                 "module example.com/m\n",
+		"x.go":                   "package m\n",
+		"p/y.go":                 "package p\n",
+		"vendor/modules.txt":     "",
+		"p/node_modules/a/a.js":  "",
+		".git/HEAD":              "ref: refs/heads/main\n",
+		"testdata/big/README.md": "",
+	})
+	if err := os.Symlink("x.go", filepath.Join(dir, "link.go")); err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(dir, "p", "y.go")
+	checks := []string{
+		"grep -q 'func F' p/y.go",
+		"test -f x.go && test -f vendor/modules.txt && test -d testdata/big",
+		"test -L link.go",
+		"test ! -e .git && test ! -e p/node_modules",
+		"echo failed in $(pwd) >&2; false",
+	}
+	result, err := runChecks(filename, []byte("package p\n\nfunc F() {}\n"), checks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.Join(result.passed, "\n"), strings.Join(checks[:4], "\n"); got != want {
+		t.Errorf("got passed checks\n%s\nwant\n%s\nfailure:\n%s", got, want, result.failure)
+	}
+	// The failure refers to the real directory,
+	// not the scratch copy.
+	if want := "$ " + checks[4] + "\nfailed in " + dir + "\n"; !strings.HasPrefix(result.failure, want) {
+		t.Errorf("got failure %q; want prefix %q", result.failure, want)
+	}
+	// The original file is left alone.
+	if data, err := os.ReadFile(filename); err != nil || string(data) != "package p\n" {
+		t.Errorf("original file changed: %q, %v", data, err)
+	}
+}
+
+func TestRunChecksOutsideModule(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"a.txt":     "a\n",
+		"b.txt":     "b\n",
+		"sub/c.txt": "c\n",
+	})
+	result, err := runChecks(filepath.Join(dir, "a.txt"), []byte("new\n"), []string{
+		"grep -q new a.txt && test -f b.txt",
+		"test -e sub",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Only the files in the file's own directory are copied.
+	if len(result.passed) != 1 || !strings.Contains(result.failure, "$ test -e sub\n") {
+		t.Errorf("got passed %q, failure %q; want only the first check to pass", result.passed, result.failure)
+	}
+}